@@ -2,21 +2,25 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/BossBossNJb/assessment-tax/tax"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	_ "github.com/lib/pq"
 )
 
 func main() {
 	e := echo.New()
+	e.HTTPErrorHandler = tax.HTTPErrorHandler
 
 	// Load environment variables from .env file
 	err := godotenv.Load()
@@ -27,9 +31,89 @@ func main() {
 	// Get the values of environment variables
 	adminUsername := os.Getenv("ADMIN_USERNAME")
 	adminPassword := os.Getenv("ADMIN_PASSWORD")
-	// databaseURL := os.Getenv("DATABASE_URL")
+	databaseURL := os.Getenv("DATABASE_URL")
 	port := os.Getenv("PORT")
 
+	// Load additional tax year rule sets if a rules file is configured
+	if rulesFile := os.Getenv("TAX_RULES_FILE"); rulesFile != "" {
+		f, err := os.Open(rulesFile)
+		if err != nil {
+			log.Fatalf("Error opening tax rules file: %v", err)
+		}
+		err = tax.LoadRuleSets(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Error loading tax rules file: %v", err)
+		}
+	}
+
+	// Store the admin deduction settings in PostgreSQL, or in memory when no database is configured
+	var settingsRepository tax.SettingsRepository
+	var auditStore tax.AuditStore
+	var jobStore tax.JobStore
+	if databaseURL != "" {
+		db, err := sql.Open("postgres", databaseURL)
+		if err != nil {
+			log.Fatalf("Error opening database: %v", err)
+		}
+		defer db.Close()
+
+		// Run the schema migrations before serving requests
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = tax.Migrate(ctx, db)
+		cancel()
+		if err != nil {
+			log.Fatalf("Error migrating database: %v", err)
+		}
+		settingsRepository = tax.NewPostgresSettingsRepository(db)
+		auditStore = tax.NewPostgresAuditStore(db)
+		jobStore = tax.NewPostgresJobStore(db)
+	} else {
+		log.Println("DATABASE_URL is not set, admin deduction settings and jobs are kept in memory")
+		memoryAuditStore := tax.NewMemoryAuditStore()
+		settingsRepository = tax.NewMemorySettingsRepository(memoryAuditStore)
+		auditStore = memoryAuditStore
+		jobStore = tax.NewMemoryJobStore()
+	}
+	settingsService, err := tax.NewSettingsService(context.Background(), settingsRepository, auditStore)
+	if err != nil {
+		log.Fatalf("Error loading deduction settings: %v", err)
+	}
+	taxHandler := tax.NewHandler(settingsService)
+
+	// Reload the deduction settings in the background to see the changes of other instances
+	settingsCtx, stopSettings := context.WithCancel(context.Background())
+	go settingsService.Run(settingsCtx)
+
+	// Bound the size of bulk calculation uploads
+	uploadLimits, err := tax.UploadLimitsFromEnv()
+	if err != nil {
+		log.Fatalf("Error reading upload limits: %v", err)
+	}
+	taxHandler.SetUploadLimits(uploadLimits)
+
+	// Calculate the rows of bulk uploads concurrently
+	workers, err := tax.WorkersFromEnv()
+	if err != nil {
+		log.Fatalf("Error reading worker count: %v", err)
+	}
+	taxHandler.SetWorkers(workers)
+
+	// Run the bulk calculation jobs in the background until the server shuts down
+	jobService := tax.NewJobService(jobStore, settingsService)
+	jobService.SetUploadLimits(uploadLimits)
+	jobService.SetWorkers(workers)
+	taxHandler.SetJobs(jobService)
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		jobService.Run(jobsCtx)
+	}()
+
+	// Assign a request ID to every request so admin changes can be traced
+	e.Use(middleware.RequestID())
+
 	// Root endpoint handler
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "Hello, Go Bootcamp!")
@@ -39,6 +123,8 @@ func main() {
 	adminAuthMiddleware := middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
 		// Check if the provided username and password match the admin credentials
 		if username == adminUsername && password == adminPassword {
+			// Keep the username for the audit trail of admin changes
+			c.Set(tax.UsernameContextKey, username)
 			return true, nil
 		}
 		return false, nil
@@ -49,29 +135,48 @@ func main() {
 	adminGroup.Use(adminAuthMiddleware)
 
 	// Define the route for setting personal deduction by admin
-	adminGroup.POST("/deductions/personal", tax.SetPersonalDeductionHandler)
+	adminGroup.POST("/deductions/personal", taxHandler.SetPersonalDeductionHandler)
 
 	// Define the route for setting k-receipt limit deduction by admin
-	adminGroup.POST("/deductions/k-receipt", tax.SetKreceipLimitDeductionHandler)
+	adminGroup.POST("/deductions/k-receipt", taxHandler.SetKreceipLimitDeductionHandler)
+
+	// Define the route for listing the history of scheduled and past deduction settings
+	adminGroup.GET("/deductions/history", taxHandler.DeductionHistoryHandler)
+
+	// Define the route for listing the audit trail of admin deduction changes
+	adminGroup.GET("/audit", taxHandler.AuditLogHandler)
 
 	// Group tax-related endpoints
 	taxGroup := e.Group("/tax")
 
 	// Tax calculation endpoint handler
-	taxGroup.POST("/calculations", tax.CalculateTaxHandler)
-	taxGroup.GET("/calculations/deteils", tax.TaxDetails)
+	taxGroup.POST("/calculations", taxHandler.CalculateTaxHandler)
+	taxGroup.GET("/calculations/deteils", taxHandler.TaxDetails)
 
 	// Tax calculation with csv
-	taxGroup.POST("/calculations/upload-csv", tax.CalculateTaxFromCSVHandler)
+	taxGroup.POST("/calculations/upload-csv", taxHandler.CalculateTaxFromCSVHandler)
+
+	// Check a csv file without calculating it
+	taxGroup.POST("/calculations/validate-csv", taxHandler.ValidateCSVHandler)
+
+	// Asynchronous bulk calculation jobs
+	taxGroup.POST("/jobs", taxHandler.SubmitJobHandler)
+	taxGroup.GET("/jobs/:id", taxHandler.JobHandler)
+	taxGroup.GET("/jobs/:id/result", taxHandler.JobResultHandler)
+	taxGroup.DELETE("/jobs/:id", taxHandler.CancelJobHandler)
 
 	// Start the server
 	fmt.Println("port:", port)
 	fmt.Println("adminUsername:", adminUsername)
-	e.Logger.Fatal(e.Start(":" + port))
+	go func() {
+		if err := e.Start(":" + port); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
 
 	// Graceful Shutdown
 	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
 	// Print "shutting down the server"
 	fmt.Println("Shutting down the server...")
@@ -80,4 +185,10 @@ func main() {
 	if err := e.Shutdown(ctx); err != nil {
 		e.Logger.Fatal(err)
 	}
+
+	// Stop the background work once no request is served anymore; a job stopped while
+	// running is claimed again by the next runner
+	stopSettings()
+	stopJobs()
+	<-jobsDone
 }
@@ -0,0 +1,388 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownAllowance is returned when an allowanceType has no registered rule.
+var ErrUnknownAllowance = errors.New("unknown allowance type")
+
+// ErrInvalidAllowance is returned when an allowance amount is rejected by its rule.
+var ErrInvalidAllowance = errors.New("invalid allowance amount")
+
+// ErrUnknownDonationType is returned when a donationType is not a category of donation.
+var ErrUnknownDonationType = errors.New("unknown donation type")
+
+// AllowanceContext carries the values an allowance rule may depend on. Income is the
+// income net of expenses, GrossIncome the assessable income before expenses, SalaryIncome
+// the salary and wages of section 40(1) and Deducted the deductions applied before the
+// allowance.
+type AllowanceContext struct {
+	Rules        RuleSet
+	Income       Money
+	GrossIncome  Money
+	SalaryIncome Money
+	Deducted     Money
+}
+
+// Incomes the IncomeRate of a CappedAllowance may be a percentage of
+const (
+	IncomeNet    = ""       // IncomeNet is the income net of expenses
+	IncomeGross  = "gross"  // IncomeGross is the assessable income before expenses
+	IncomeSalary = "salary" // IncomeSalary is the salary and wages of section 40(1)
+)
+
+// income returns the income of the context named by base
+func (ctx AllowanceContext) income(base string) Money {
+	switch base {
+	case IncomeGross:
+		return ctx.GrossIncome
+	case IncomeSalary:
+		return ctx.SalaryIncome
+	}
+	return ctx.Income
+}
+
+// AllowanceRule represents the deduction rule of one allowance type.
+type AllowanceRule interface {
+	// Type returns the allowanceType handled by the rule.
+	Type() string
+	// Validate checks the requested amount of the allowance.
+	Validate(amount Money) error
+	// Deduct returns the deductible part of the requested amount.
+	Deduct(amount Money, ctx AllowanceContext) Money
+}
+
+// CappedAllowance is an AllowanceRule limited by a fixed cap and, optionally,
+// by a percentage of the income.
+type CappedAllowance struct {
+	Name string
+	// Cap returns the maximum deduction. A nil Cap means no fixed cap.
+	Cap func(ctx AllowanceContext) Money
+	// IncomeRate limits the deduction to a percentage of the income when it is not 0.
+	IncomeRate Rate
+	// IncomeBase names the income IncomeRate is a percentage of, the net income by default.
+	IncomeBase string
+	// AfterOtherAllowances applies the rule after every other allowance, with IncomeRate
+	// limiting the deduction to a percentage of the income after the deductions before it.
+	AfterOtherAllowances bool
+	// Group names the allowance group whose combined cap the rule shares, if any.
+	Group string
+}
+
+// Type returns the allowanceType handled by the rule.
+func (a CappedAllowance) Type() string {
+	return a.Name
+}
+
+// Validate checks that the requested amount is not negative.
+func (a CappedAllowance) Validate(amount Money) error {
+	if amount < 0 {
+		return fmt.Errorf("%w: %s must not be negative", ErrInvalidAllowance, a.Name)
+	}
+	return nil
+}
+
+// Deduct returns the requested amount limited by the caps of the rule.
+func (a CappedAllowance) Deduct(amount Money, ctx AllowanceContext) Money {
+	if limit, ok := a.Limit(ctx); ok {
+		amount = amount.Min(limit)
+	}
+	if amount < 0 {
+		return 0
+	}
+	return amount
+}
+
+// Limit returns the maximum deduction of the rule, the lowest of its caps, and false
+// when the rule has no cap.
+func (a CappedAllowance) Limit(ctx AllowanceContext) (Money, bool) {
+	var limit Money
+	capped := false
+	if a.Cap != nil {
+		limit, capped = a.Cap(ctx), true
+	}
+	if a.IncomeRate > 0 {
+		income := ctx.income(a.IncomeBase)
+		if a.AfterOtherAllowances {
+			income -= ctx.Deducted
+		}
+		if rateCap := income.MulRate(a.IncomeRate); !capped || rateCap < limit {
+			limit, capped = rateCap, true
+		}
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	return limit, capped
+}
+
+// AppliedLast reports whether the rule is applied after every other allowance.
+func (a CappedAllowance) AppliedLast() bool {
+	return a.AfterOtherAllowances
+}
+
+// CapGroup returns the allowance group whose combined cap the rule shares.
+func (a CappedAllowance) CapGroup() string {
+	return a.Group
+}
+
+// groupedAllowanceRule is implemented by rules that may share the cap of an allowance group
+type groupedAllowanceRule interface {
+	CapGroup() string
+}
+
+// capGroup returns the allowance group of a rule, or "" when it has none
+func capGroup(rule AllowanceRule) string {
+	if grouped, ok := rule.(groupedAllowanceRule); ok {
+		return grouped.CapGroup()
+	}
+	return ""
+}
+
+// AllowanceGroupRetirement groups the retirement savings and investment allowances
+const AllowanceGroupRetirement = "retirement"
+
+// allowanceGroupCaps are the combined caps of the allowance groups
+var allowanceGroupCaps = map[string]Money{
+	AllowanceGroupRetirement: 500000 * Baht,
+}
+
+// lastAllowanceRule is implemented by rules that may be applied after every other allowance
+type lastAllowanceRule interface {
+	AppliedLast() bool
+}
+
+// appliedLast reports whether a rule is applied after every other allowance
+func appliedLast(rule AllowanceRule) bool {
+	last, ok := rule.(lastAllowanceRule)
+	return ok && last.AppliedLast()
+}
+
+// fixedCap returns a Cap function for a fixed amount.
+func fixedCap(amount Money) func(AllowanceContext) Money {
+	return func(AllowanceContext) Money { return amount }
+}
+
+// donationCap returns the fixed donation cap of the rule set, or the whole income when
+// the rule set has none
+func donationCap(ctx AllowanceContext) Money {
+	if ctx.Rules.DonationCap == 0 {
+		return ctx.Income
+	}
+	return ctx.Rules.DonationCap
+}
+
+// donationAllowanceType is the allowanceType of donations
+const donationAllowanceType = "donation"
+
+// Categories of donations
+const (
+	DonationGeneral   = "general"   // Donations to charities, deducted at their amount
+	DonationEducation = "education" // Donations to educational institutions, deducted at twice their amount
+	DonationSports    = "sports"    // Donations for sports development, deducted at twice their amount
+	DonationHospital  = "hospital"  // Donations to public hospitals, deducted at twice their amount
+)
+
+// donationMultipliers are the rates at which the categories of donations are deducted.
+// A donation without a donationType is a general donation.
+var donationMultipliers = map[string]Rate{
+	"":                100 * Percent,
+	DonationGeneral:   100 * Percent,
+	DonationEducation: 200 * Percent,
+	DonationSports:    200 * Percent,
+	DonationHospital:  200 * Percent,
+}
+
+var (
+	allowanceRulesMu sync.RWMutex
+	allowanceRules   = map[string]AllowanceRule{}
+)
+
+func init() {
+	for _, rule := range []AllowanceRule{
+		CappedAllowance{Name: donationAllowanceType, Cap: donationCap, IncomeRate: 10 * Percent, AfterOtherAllowances: true},
+		CappedAllowance{Name: "k-receipt", Cap: func(ctx AllowanceContext) Money { return ctx.Rules.KreceiptCap }},
+		CappedAllowance{Name: "life-insurance", Cap: fixedCap(100000 * Baht)},
+		CappedAllowance{Name: "health-insurance", Cap: fixedCap(25000 * Baht)},
+		CappedAllowance{Name: "parent-health-insurance", Cap: fixedCap(15000 * Baht)},
+		CappedAllowance{Name: "prenatal-care", Cap: fixedCap(60000 * Baht)},
+		CappedAllowance{Name: "ssf", Cap: fixedCap(200000 * Baht), IncomeRate: 30 * Percent, IncomeBase: IncomeGross, Group: AllowanceGroupRetirement},
+		CappedAllowance{Name: "rmf", Cap: fixedCap(500000 * Baht), IncomeRate: 30 * Percent, IncomeBase: IncomeGross, Group: AllowanceGroupRetirement},
+		CappedAllowance{Name: "provident-fund", Cap: fixedCap(500000 * Baht), IncomeRate: 15 * Percent, IncomeBase: IncomeSalary, Group: AllowanceGroupRetirement},
+		CappedAllowance{Name: "government-pension-fund", Cap: fixedCap(500000 * Baht), IncomeRate: 30 * Percent, IncomeBase: IncomeGross, Group: AllowanceGroupRetirement},
+		CappedAllowance{Name: "pension-insurance", Cap: fixedCap(200000 * Baht), IncomeRate: 15 * Percent, IncomeBase: IncomeGross, Group: AllowanceGroupRetirement},
+		CappedAllowance{Name: "social-security", Cap: fixedCap(9000 * Baht)},
+		CappedAllowance{Name: "home-loan-interest", Cap: fixedCap(100000 * Baht)},
+	} {
+		RegisterAllowanceRule(rule)
+	}
+}
+
+// RegisterAllowanceRule adds or replaces the rule of an allowance type.
+func RegisterAllowanceRule(rule AllowanceRule) {
+	allowanceRulesMu.Lock()
+	defer allowanceRulesMu.Unlock()
+	allowanceRules[rule.Type()] = rule
+}
+
+// LookupAllowanceRule returns the rule of an allowance type.
+func LookupAllowanceRule(allowanceType string) (AllowanceRule, bool) {
+	allowanceRulesMu.RLock()
+	defer allowanceRulesMu.RUnlock()
+	rule, ok := allowanceRules[allowanceType]
+	return rule, ok
+}
+
+// AllowanceTypes returns the registered allowance types in alphabetical order.
+func AllowanceTypes() []string {
+	allowanceRulesMu.RLock()
+	defer allowanceRulesMu.RUnlock()
+
+	types := make([]string, 0, len(allowanceRules))
+	for allowanceType := range allowanceRules {
+		types = append(types, allowanceType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// validateAllowance checks the allowance at index n of a request, returning its rule and
+// the rate at which its amount is counted
+func validateAllowance(n int, allowance Allowance) (AllowanceRule, Rate, error) {
+	rule, ok := LookupAllowanceRule(allowance.AllowanceType)
+	if !ok {
+		err := fmt.Errorf("%w: %q", ErrUnknownAllowance, allowance.AllowanceType)
+		return nil, 0, fieldError(err, fmt.Sprintf("/allowances/%d/allowanceType", n), err.Error())
+	}
+	if err := rule.Validate(allowance.Amount); err != nil {
+		return nil, 0, fieldError(err, fmt.Sprintf("/allowances/%d/amount", n), err.Error())
+	}
+	multiplier, ok := donationMultipliers[allowance.DonationType]
+	if !ok || (allowance.DonationType != "" && allowance.AllowanceType != donationAllowanceType) {
+		err := fmt.Errorf("%w: %q", ErrUnknownDonationType, allowance.DonationType)
+		if ok {
+			err = fmt.Errorf("%w: donationType is only allowed on donation", ErrUnknownDonationType)
+		}
+		return nil, 0, fieldError(err, fmt.Sprintf("/allowances/%d/donationType", n), err.Error())
+	}
+	return rule, multiplier, nil
+}
+
+// appliedAllowance represents the requested and deductible amount of one allowance type.
+// Eligible is the requested amount counted for the deduction, with doubled donations
+// counted twice, and Context the context the rule was applied with.
+type appliedAllowance struct {
+	Type      string
+	Group     string
+	Requested Money
+	Eligible  Money
+	Deducted  Money
+	Context   AllowanceContext
+}
+
+// applyAllowances validates every allowance with its rule, sums the requested
+// amounts per allowance type and returns the deductible amount of each type in request order.
+// Rules applied last, such as donations, are applied after every other allowance, with
+// the other allowances added to the deductions of ctx. The combined cap of an allowance
+// group is used up in request order.
+func applyAllowances(allowances []Allowance, ctx AllowanceContext) ([]appliedAllowance, error) {
+	var applied []appliedAllowance
+	rules := map[string]AllowanceRule{}
+	index := map[string]int{}
+	for n, allowance := range allowances {
+		rule, multiplier, err := validateAllowance(n, allowance)
+		if err != nil {
+			return nil, err
+		}
+
+		i, ok := index[allowance.AllowanceType]
+		if !ok {
+			i = len(applied)
+			index[allowance.AllowanceType] = i
+			rules[allowance.AllowanceType] = rule
+			applied = append(applied, appliedAllowance{Type: allowance.AllowanceType, Group: capGroup(rule)})
+		}
+		applied[i].Requested += allowance.Amount
+		applied[i].Eligible += allowance.Amount.MulRate(multiplier)
+	}
+
+	// Apply the other allowances first, then the rules applied last on the income
+	// after them
+	used := map[string]Money{}
+	for _, last := range []bool{false, true} {
+		var deducted Money
+		for i, a := range applied {
+			if appliedLast(rules[a.Type]) != last {
+				continue
+			}
+			applied[i].Context = ctx
+			applied[i].Deducted = rules[a.Type].Deduct(a.Eligible, ctx)
+
+			// Deduct within the remaining combined cap of the group
+			if groupCap, ok := allowanceGroupCaps[a.Group]; ok {
+				applied[i].Deducted = applied[i].Deducted.Min(groupCap - used[a.Group])
+				used[a.Group] += applied[i].Deducted
+			}
+			deducted += applied[i].Deducted
+		}
+		ctx.Deducted += deducted
+	}
+	return applied, nil
+}
+
+// DonationDeduction explains the deduction of donations: the requested amount, the
+// eligible amount with doubled categories counted twice and the cap of Rate of the
+// income after every other deduction, limited by the fixed cap of the rule set.
+type DonationDeduction struct {
+	Requested             Money `json:"requested"`
+	Eligible              Money `json:"eligible"`
+	IncomeAfterDeductions Money `json:"incomeAfterDeductions"`
+	Rate                  Rate  `json:"rate"`
+	Cap                   Money `json:"cap"`
+	Deducted              Money `json:"deducted"`
+}
+
+// explainDonation returns the DonationDeduction of the applied allowances, or nil when
+// no donation was requested or the donation rule is not a CappedAllowance
+func explainDonation(applied []appliedAllowance) *DonationDeduction {
+	rule, ok := LookupAllowanceRule(donationAllowanceType)
+	capped, isCapped := rule.(CappedAllowance)
+	if !ok || !isCapped {
+		return nil
+	}
+	for _, a := range applied {
+		if a.Type != donationAllowanceType {
+			continue
+		}
+		income := a.Context.Income
+		if capped.AfterOtherAllowances {
+			income -= a.Context.Deducted
+		}
+		if income < 0 {
+			income = 0
+		}
+		limit, _ := capped.Limit(a.Context)
+		return &DonationDeduction{
+			Requested:             a.Requested,
+			Eligible:              a.Eligible,
+			IncomeAfterDeductions: income,
+			Rate:                  capped.IncomeRate,
+			Cap:                   limit,
+			Deducted:              a.Deducted,
+		}
+	}
+	return nil
+}
+
+// AllowanceSummary reports how much of the requested amount of an allowance type was
+// allowed, after its own caps and the combined cap of its Group.
+type AllowanceSummary struct {
+	AllowanceType string `json:"allowanceType"`
+	Group         string `json:"group,omitempty"`
+	Requested     Money  `json:"requested"`
+	Allowed       Money  `json:"allowed"`
+}
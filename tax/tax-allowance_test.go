@@ -0,0 +1,279 @@
+package tax
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyAllowances(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	ctx := AllowanceContext{Rules: rules, Income: 500000 * Baht, GrossIncome: 600000 * Baht, SalaryIncome: 600000 * Baht}
+
+	testCases := []struct {
+		name             string
+		allowances       []Allowance
+		expectedDeducted Money
+	}{
+		{"donation capped by 10% of income", []Allowance{{AllowanceType: "donation", Amount: 200000 * Baht}}, 50000 * Baht},
+		{"k-receipt capped by rule set", []Allowance{{AllowanceType: "k-receipt", Amount: 60000 * Baht}}, 50000 * Baht},
+		{"life insurance below cap", []Allowance{{AllowanceType: "life-insurance", Amount: 30000 * Baht}}, 30000 * Baht},
+		{"health insurance capped", []Allowance{{AllowanceType: "health-insurance", Amount: 40000 * Baht}}, 25000 * Baht},
+		{"ssf capped by 30% of gross income", []Allowance{{AllowanceType: "ssf", Amount: 190000 * Baht}}, 180000 * Baht},
+		{"same type is summed before capping", []Allowance{{AllowanceType: "social-security", Amount: 5000 * Baht}, {AllowanceType: "social-security", Amount: 5000 * Baht}}, 9000 * Baht},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			applied, err := applyAllowances(tc.allowances, ctx)
+			assert.NoError(t, err)
+			assert.Len(t, applied, 1)
+			assert.Equal(t, tc.expectedDeducted, applied[0].Deducted)
+		})
+	}
+}
+
+func TestApplyAllowancesInvalid(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	ctx := AllowanceContext{Rules: rules, Income: 500000 * Baht}
+
+	_, err = applyAllowances([]Allowance{{AllowanceType: "lottery", Amount: 1000 * Baht}}, ctx)
+	assert.ErrorIs(t, err, ErrUnknownAllowance)
+
+	_, err = applyAllowances([]Allowance{{AllowanceType: "donation", Amount: -1000 * Baht}}, ctx)
+	assert.ErrorIs(t, err, ErrInvalidAllowance)
+}
+
+func TestApplyAllowancesDonations(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	ctx := AllowanceContext{Rules: rules, Income: 500000 * Baht, Deducted: 60000 * Baht}
+
+	testCases := []struct {
+		name             string
+		rules            RuleSet
+		allowances       []Allowance
+		expectedEligible Money
+		expectedDeducted Money
+	}{
+		{
+			name:             "capped by 10% of the income after deductions",
+			rules:            rules,
+			allowances:       []Allowance{{AllowanceType: "donation", Amount: 200000 * Baht}},
+			expectedEligible: 200000 * Baht,
+			expectedDeducted: 44000 * Baht,
+		},
+		{
+			name:             "education donations are doubled",
+			rules:            rules,
+			allowances:       []Allowance{{AllowanceType: "donation", Amount: 10000 * Baht, DonationType: DonationEducation}, {AllowanceType: "donation", Amount: 5000 * Baht}},
+			expectedEligible: 25000 * Baht,
+			expectedDeducted: 25000 * Baht,
+		},
+		{
+			name:             "applied after other allowances",
+			rules:            rules,
+			allowances:       []Allowance{{AllowanceType: "donation", Amount: 20000 * Baht, DonationType: DonationHospital}, {AllowanceType: "life-insurance", Amount: 100000 * Baht}},
+			expectedEligible: 40000 * Baht,
+			expectedDeducted: 34000 * Baht,
+		},
+		{
+			name:             "capped by the fixed cap of the rule set",
+			rules:            RuleSet{DonationCap: 10000 * Baht},
+			allowances:       []Allowance{{AllowanceType: "donation", Amount: 20000 * Baht, DonationType: DonationSports}},
+			expectedEligible: 40000 * Baht,
+			expectedDeducted: 10000 * Baht,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := ctx
+			ctx.Rules = tc.rules
+			applied, err := applyAllowances(tc.allowances, ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, "donation", applied[0].Type)
+			assert.Equal(t, tc.expectedEligible, applied[0].Eligible)
+			assert.Equal(t, tc.expectedDeducted, applied[0].Deducted)
+		})
+	}
+}
+
+func TestApplyAllowancesRetirementGroup(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	ctx := AllowanceContext{Rules: rules, Income: 2000000 * Baht, GrossIncome: 2000000 * Baht, SalaryIncome: 2000000 * Baht}
+
+	testCases := []struct {
+		name             string
+		allowances       []Allowance
+		expectedDeducted []Money
+	}{
+		{
+			name:             "provident fund capped by 15% of salary",
+			allowances:       []Allowance{{AllowanceType: "provident-fund", Amount: 400000 * Baht}},
+			expectedDeducted: []Money{300000 * Baht},
+		},
+		{
+			name:             "pension insurance capped by its own cap",
+			allowances:       []Allowance{{AllowanceType: "pension-insurance", Amount: 250000 * Baht}},
+			expectedDeducted: []Money{200000 * Baht},
+		},
+		{
+			name: "combined cap used up in request order",
+			allowances: []Allowance{
+				{AllowanceType: "rmf", Amount: 400000 * Baht},
+				{AllowanceType: "ssf", Amount: 200000 * Baht},
+				{AllowanceType: "government-pension-fund", Amount: 100000 * Baht},
+				{AllowanceType: "life-insurance", Amount: 100000 * Baht},
+			},
+			expectedDeducted: []Money{400000 * Baht, 100000 * Baht, 0, 100000 * Baht},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			applied, err := applyAllowances(tc.allowances, ctx)
+			assert.NoError(t, err)
+			deducted := make([]Money, 0, len(applied))
+			for _, a := range applied {
+				deducted = append(deducted, a.Deducted)
+			}
+			assert.Equal(t, tc.expectedDeducted, deducted)
+		})
+	}
+}
+
+func TestCalculateReportsAllowedAllowances(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+
+	response, err := rules.Calculate(CalculationRequest{
+		TotalIncome: 2000000 * Baht,
+		Allowances: []Allowance{
+			{AllowanceType: "pension-insurance", Amount: 150000 * Baht},
+			{AllowanceType: "rmf", Amount: 300000 * Baht},
+			{AllowanceType: "ssf", Amount: 100000 * Baht},
+			{AllowanceType: "k-receipt", Amount: 60000 * Baht},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []AllowanceSummary{
+		{AllowanceType: "pension-insurance", Group: AllowanceGroupRetirement, Requested: 150000 * Baht, Allowed: 150000 * Baht},
+		{AllowanceType: "rmf", Group: AllowanceGroupRetirement, Requested: 300000 * Baht, Allowed: 300000 * Baht},
+		{AllowanceType: "ssf", Group: AllowanceGroupRetirement, Requested: 100000 * Baht, Allowed: 50000 * Baht},
+		{AllowanceType: "k-receipt", Requested: 60000 * Baht, Allowed: 50000 * Baht},
+	}, response.Allowances)
+	assert.Equal(t, 1390000*Baht, response.TaxableIncome)
+}
+
+func TestApplyAllowancesInvalidDonationType(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	ctx := AllowanceContext{Rules: rules, Income: 500000 * Baht}
+
+	_, err = applyAllowances([]Allowance{{AllowanceType: "donation", Amount: 1000 * Baht, DonationType: "temple"}}, ctx)
+	assert.ErrorIs(t, err, ErrUnknownDonationType)
+
+	_, err = applyAllowances([]Allowance{{AllowanceType: "k-receipt", Amount: 1000 * Baht, DonationType: DonationEducation}}, ctx)
+	assert.ErrorIs(t, err, ErrUnknownDonationType)
+}
+
+func TestCalculateExplainsDonationCap(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+
+	response, err := rules.Calculate(CalculationRequest{
+		TotalIncome: 500000 * Baht,
+		Allowances: []Allowance{
+			{AllowanceType: "donation", Amount: 30000 * Baht, DonationType: DonationEducation},
+			{AllowanceType: "k-receipt", Amount: 200000 * Baht},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &DonationDeduction{
+		Requested:             30000 * Baht,
+		Eligible:              60000 * Baht,
+		IncomeAfterDeductions: 390000 * Baht,
+		Rate:                  10 * Percent,
+		Cap:                   39000 * Baht,
+		Deducted:              39000 * Baht,
+	}, response.Donation)
+	assert.Equal(t, []Deduction{
+		{Type: SettingPersonalDeduction, Amount: 60000 * Baht},
+		{Type: "donation", Amount: 39000 * Baht},
+		{Type: "k-receipt", Amount: 50000 * Baht},
+	}, response.Deductions)
+	assert.Equal(t, 351000*Baht, response.TaxableIncome)
+
+	response, err = rules.Calculate(CalculationRequest{TotalIncome: 500000 * Baht})
+	assert.NoError(t, err)
+	assert.Nil(t, response.Donation)
+}
+
+func TestCalculateTaxHandlerRejectsUnknownAllowance(t *testing.T) {
+	e := echo.New()
+
+	requestBody := `{"totalIncome": 500000.0, "wht": 0.0, "allowances": [{"allowanceType": "lottery", "amount": 1000.0}]}`
+	req := httptest.NewRequest(http.MethodPost, "/tax/calculations", bytes.NewBufferString(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	err := newTestHandler(t).CalculateTaxHandler(c)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestCalculateRetirementIncomeBase(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name            string
+		incomes         []Income
+		allowance       Allowance
+		expectedAllowed Money
+	}{
+		{
+			name:            "ssf capped by 30% of gross income",
+			incomes:         []Income{{IncomeType: "40(1)", Amount: 400000 * Baht}},
+			allowance:       Allowance{AllowanceType: "ssf", Amount: 150000 * Baht},
+			expectedAllowed: 120000 * Baht,
+		},
+		{
+			name:            "rmf capped by 30% of every income",
+			incomes:         []Income{{IncomeType: "40(1)", Amount: 400000 * Baht}, {IncomeType: "40(8)", Amount: 600000 * Baht}},
+			allowance:       Allowance{AllowanceType: "rmf", Amount: 400000 * Baht},
+			expectedAllowed: 300000 * Baht,
+		},
+		{
+			name:            "provident fund capped by 15% of salary",
+			incomes:         []Income{{IncomeType: "40(1)", Amount: 400000 * Baht}, {IncomeType: "40(8)", Amount: 600000 * Baht}},
+			allowance:       Allowance{AllowanceType: "provident-fund", Amount: 100000 * Baht},
+			expectedAllowed: 60000 * Baht,
+		},
+		{
+			name:            "provident fund without salary",
+			incomes:         []Income{{IncomeType: "40(8)", Amount: 600000 * Baht}},
+			allowance:       Allowance{AllowanceType: "provident-fund", Amount: 100000 * Baht},
+			expectedAllowed: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := CalculationRequest{Incomes: tc.incomes, Allowances: []Allowance{tc.allowance}}
+			request.TotalIncome = request.grossIncome()
+			response, err := rules.Calculate(request)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedAllowed, response.Allowances[0].Allowed)
+		})
+	}
+}
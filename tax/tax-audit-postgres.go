@@ -0,0 +1,85 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// PostgresAuditStore is an AuditStore stored in PostgreSQL. The table rejects
+// updates and deletes, so entries can only be appended.
+type PostgresAuditStore struct {
+	db *sql.DB
+}
+
+// NewPostgresAuditStore creates a PostgresAuditStore.
+func NewPostgresAuditStore(db *sql.DB) *PostgresAuditStore {
+	return &PostgresAuditStore{db: db}
+}
+
+// AppendAudit stores a new entry.
+func (s *PostgresAuditStore) AppendAudit(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	return insertAudit(ctx, s.db, entry)
+}
+
+// insertAudit inserts an entry with q, which is the database or a transaction
+// storing the entry with the change it records
+func insertAudit(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, entry AuditEntry) (AuditEntry, error) {
+	err := q.QueryRowContext(ctx, `
+		INSERT INTO deduction_audit (setting, old_value_satang, new_value_satang, effective_from, username, request_id, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		entry.Setting, int64(entry.OldValue), int64(entry.NewValue), entry.EffectiveFrom, entry.Username, entry.RequestID, entry.ChangedAt,
+	).Scan(&entry.ID)
+	return entry, err
+}
+
+// ListAudit returns the entries selected by the filter, oldest first.
+func (s *PostgresAuditStore) ListAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Setting != "" {
+		args = append(args, filter.Setting)
+		conditions = append(conditions, fmt.Sprintf("setting = $%d", len(args)))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("changed_at >= $%d", len(args)))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("changed_at < $%d", len(args)))
+	}
+
+	query := `SELECT id, setting, old_value_satang, new_value_satang, effective_from, username, request_id, changed_at FROM deduction_audit`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY changed_at, id"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var effectiveFrom sql.NullTime
+		if err := rows.Scan(&entry.ID, &entry.Setting, &entry.OldValue, &entry.NewValue, &effectiveFrom, &entry.Username, &entry.RequestID, &entry.ChangedAt); err != nil {
+			return nil, err
+		}
+
+		// Entries recorded before effective dates existed took effect when they were made
+		entry.EffectiveFrom = entry.ChangedAt
+		if effectiveFrom.Valid {
+			entry.EffectiveFrom = effectiveFrom.Time
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
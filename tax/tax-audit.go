@@ -0,0 +1,94 @@
+package tax
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// UsernameContextKey is the echo context key holding the authenticated admin username.
+const UsernameContextKey = "username"
+
+// Actor identifies who made an admin change and in which request.
+type Actor struct {
+	Username  string
+	RequestID string
+}
+
+// AuditEntry records one admin change of a deduction setting. OldValue is the
+// value that was in effect at EffectiveFrom before the change.
+type AuditEntry struct {
+	ID            int64     `json:"id"`
+	Setting       string    `json:"setting"`
+	OldValue      Money     `json:"oldValue"`
+	NewValue      Money     `json:"newValue"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	Username      string    `json:"username"`
+	RequestID     string    `json:"requestId"`
+	ChangedAt     time.Time `json:"changedAt"`
+}
+
+// AuditFilter selects audit entries. Zero fields do not filter.
+// From is inclusive and To is exclusive.
+type AuditFilter struct {
+	Setting string
+	From    time.Time
+	To      time.Time
+}
+
+// Match reports whether the entry is selected by the filter.
+func (f AuditFilter) Match(entry AuditEntry) bool {
+	if f.Setting != "" && entry.Setting != f.Setting {
+		return false
+	}
+	if !f.From.IsZero() && entry.ChangedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !entry.ChangedAt.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// AuditStore is an append-only store of audit entries.
+type AuditStore interface {
+	// AppendAudit stores a new entry and returns it with its ID set.
+	AppendAudit(ctx context.Context, entry AuditEntry) (AuditEntry, error)
+	// ListAudit returns the entries selected by the filter, oldest first.
+	ListAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
+
+// MemoryAuditStore is an AuditStore kept in memory.
+type MemoryAuditStore struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+// NewMemoryAuditStore creates an empty MemoryAuditStore.
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{}
+}
+
+// AppendAudit stores a new entry in memory.
+func (s *MemoryAuditStore) AppendAudit(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = int64(len(s.entries) + 1)
+	s.entries = append(s.entries, entry)
+	return entry, nil
+}
+
+// ListAudit returns the entries selected by the filter, oldest first.
+func (s *MemoryAuditStore) ListAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []AuditEntry{}
+	for _, entry := range s.entries {
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,115 @@
+package tax
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditFilterMatch(t *testing.T) {
+	entry := AuditEntry{Setting: SettingPersonalDeduction, ChangedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+
+	testCases := []struct {
+		name     string
+		filter   AuditFilter
+		expected bool
+	}{
+		{"no filter", AuditFilter{}, true},
+		{"same setting", AuditFilter{Setting: SettingPersonalDeduction}, true},
+		{"other setting", AuditFilter{Setting: SettingKreceiptLimit}, false},
+		{"from is inclusive", AuditFilter{From: entry.ChangedAt}, true},
+		{"to is exclusive", AuditFilter{To: entry.ChangedAt}, false},
+		{"inside range", AuditFilter{From: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.filter.Match(entry))
+		})
+	}
+}
+
+func TestAuditLogHandler(t *testing.T) {
+	e := echo.New()
+	h := newTestHandler(t)
+
+	// Change the personal deduction as an authenticated admin
+	req := httptest.NewRequest(http.MethodPost, "/admin/deductions/personal", bytes.NewBufferString(`{"amount": 70000.0}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderXRequestID, "req-1")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(UsernameContextKey, "adminTax")
+	assert.NoError(t, h.SetPersonalDeductionHandler(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// A rejected change is not recorded
+	req = httptest.NewRequest(http.MethodPost, "/admin/deductions/k-receipt", bytes.NewBufferString(`{"amount": 500000.0}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	assert.NoError(t, h.SetKreceipLimitDeductionHandler(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/audit?setting=personal", nil)
+	rec = httptest.NewRecorder()
+	assert.NoError(t, h.AuditLogHandler(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response struct {
+		Audit []AuditEntry `json:"audit"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	if assert.Len(t, response.Audit, 1) {
+		entry := response.Audit[0]
+		assert.Equal(t, SettingPersonalDeduction, entry.Setting)
+		assert.Equal(t, 60000*Baht, entry.OldValue)
+		assert.Equal(t, 70000*Baht, entry.NewValue)
+		assert.Equal(t, "adminTax", entry.Username)
+		assert.Equal(t, "req-1", entry.RequestID)
+	}
+
+	// Entries outside the time range are filtered out
+	req = httptest.NewRequest(http.MethodGet, "/admin/audit?to=2000-01-01", nil)
+	rec = httptest.NewRecorder()
+	assert.NoError(t, h.AuditLogHandler(e.NewContext(req, rec)))
+	assert.Equal(t, `{"audit":[]}`, string(bytes.TrimSpace(rec.Body.Bytes())))
+
+	// Invalid filters are rejected
+	req = httptest.NewRequest(http.MethodGet, "/admin/audit?from=yesterday", nil)
+	rec = httptest.NewRecorder()
+	assert.NoError(t, h.AuditLogHandler(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPostgresAuditStore(t *testing.T) {
+	db := openTestDatabase(t)
+	ctx := context.Background()
+	store := NewPostgresAuditStore(db)
+
+	changedAt := time.Now().UTC().Truncate(time.Microsecond)
+	entry, err := store.AppendAudit(ctx, AuditEntry{
+		Setting:   SettingKreceiptLimit,
+		OldValue:  50000 * Baht,
+		NewValue:  70000 * Baht,
+		Username:  "adminTax",
+		RequestID: "req-1",
+		ChangedAt: changedAt,
+	})
+	assert.NoError(t, err)
+	assert.NotZero(t, entry.ID)
+
+	entries, err := store.ListAudit(ctx, AuditFilter{Setting: SettingKreceiptLimit, From: changedAt})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, entries)
+
+	// The audit table is append-only
+	_, err = db.ExecContext(ctx, `DELETE FROM deduction_audit WHERE id = $1`, entry.ID)
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bracket represents one band of the progressive tax table.
+// An Upper of 0 means the band has no upper bound.
+type Bracket struct {
+	Lower Money `json:"lower"`
+	Upper Money `json:"upper"`
+	Rate  Rate  `json:"rate"`
+}
+
+// BracketTable represents the ordered bands of the progressive tax table.
+type BracketTable []Bracket
+
+// DefaultBracketTable is the progressive tax table for tax year 2567.
+var DefaultBracketTable = BracketTable{
+	{Lower: 0, Upper: 150000 * Baht, Rate: 0},
+	{Lower: 150000 * Baht, Upper: 500000 * Baht, Rate: 10 * Percent},
+	{Lower: 500000 * Baht, Upper: 1000000 * Baht, Rate: 15 * Percent},
+	{Lower: 1000000 * Baht, Upper: 2000000 * Baht, Rate: 20 * Percent},
+	{Lower: 2000000 * Baht, Upper: 0, Rate: 35 * Percent},
+}
+
+// Unbounded reports whether the band has no upper bound.
+func (b Bracket) Unbounded() bool {
+	return b.Upper == 0
+}
+
+// Label returns the display label of the band, e.g. "150,001-500,000".
+func (b Bracket) Label() string {
+	lower := formatAmount(b.Lower)
+	if b.Lower > 0 {
+		lower = formatAmount(b.Lower + Baht)
+	}
+	if b.Unbounded() {
+		return lower + " ขึ้นไป"
+	}
+	return lower + "-" + formatAmount(b.Upper)
+}
+
+// Tax calculates the tax of the band for the given taxable income,
+// rounded half away from zero to the satang.
+func (b Bracket) Tax(taxableIncome Money) Money {
+	if taxableIncome <= b.Lower {
+		return 0
+	}
+	portion := taxableIncome
+	if !b.Unbounded() && portion > b.Upper {
+		portion = b.Upper
+	}
+	return (portion - b.Lower).MulRate(b.Rate)
+}
+
+// Validate checks that the bands start at 0, are contiguous and ascending,
+// have rates between 0 and 1 and that only the last band is unbounded.
+func (t BracketTable) Validate() error {
+	if len(t) == 0 {
+		return errors.New("bracket table must have at least one band")
+	}
+	if t[0].Lower != 0 {
+		return errors.New("first band must start at 0")
+	}
+	for i, b := range t {
+		if b.Rate < 0 || b.Rate > 100*Percent {
+			return fmt.Errorf("band %d: rate must be between 0 and 1", i+1)
+		}
+		if i > 0 && b.Lower != t[i-1].Upper {
+			return fmt.Errorf("band %d: lower bound must equal the upper bound of the previous band", i+1)
+		}
+		if b.Unbounded() {
+			if i != len(t)-1 {
+				return fmt.Errorf("band %d: only the last band can be unbounded", i+1)
+			}
+			continue
+		}
+		if b.Upper <= b.Lower {
+			return fmt.Errorf("band %d: upper bound must be greater than lower bound", i+1)
+		}
+	}
+	return nil
+}
+
+// Levels calculates the tax of every band for the given taxable income.
+func (t BracketTable) Levels(taxableIncome Money) []TaxLevel {
+	levels := make([]TaxLevel, 0, len(t))
+	for _, b := range t {
+		levels = append(levels, TaxLevel{Level: b.Label(), Tax: b.Tax(taxableIncome)})
+	}
+	return levels
+}
+
+// formatAmount formats an amount with thousands separators, e.g. 150001 baht -> "150,001".
+// Satang are only shown when the amount is not a whole number of baht.
+func formatAmount(amount Money) string {
+	whole := strconv.FormatInt(int64(amount/Baht), 10)
+
+	var b strings.Builder
+	b.Grow(len(whole) + len(whole)/3 + 3)
+	for i := 0; i < len(whole); i++ {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte(whole[i])
+	}
+	if satang := amount % Baht; satang != 0 {
+		fmt.Fprintf(&b, ".%02d", satang)
+	}
+	return b.String()
+}
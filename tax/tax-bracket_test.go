@@ -0,0 +1,64 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBracketLabel(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bracket  Bracket
+		expected string
+	}{
+		{"first band", Bracket{Lower: 0, Upper: 150000 * Baht}, "0-150,000"},
+		{"middle band", Bracket{Lower: 1000000 * Baht, Upper: 2000000 * Baht}, "1,000,001-2,000,000"},
+		{"unbounded band", Bracket{Lower: 2000000 * Baht}, "2,000,001 ขึ้นไป"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.bracket.Label())
+		})
+	}
+}
+
+func TestBracketTableValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		table   BracketTable
+		wantErr bool
+	}{
+		{"default table", DefaultBracketTable, false},
+		{"empty table", BracketTable{}, true},
+		{"first band not at 0", BracketTable{{Lower: 100 * Baht, Upper: 0, Rate: 10 * Percent}}, true},
+		{"gap between bands", BracketTable{{Lower: 0, Upper: 100 * Baht}, {Lower: 200 * Baht, Rate: 10 * Percent}}, true},
+		{"unbounded band not last", BracketTable{{Lower: 0}, {Lower: 0, Upper: 100 * Baht}}, true},
+		{"rate above 100%", BracketTable{{Lower: 0, Rate: 150 * Percent}}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.table.Validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBracketTableLevels(t *testing.T) {
+	levels := DefaultBracketTable.Levels(2500000 * Baht)
+
+	expected := []TaxLevel{
+		{"0-150,000", 0},
+		{"150,001-500,000", 35000 * Baht},
+		{"500,001-1,000,000", 75000 * Baht},
+		{"1,000,001-2,000,000", 200000 * Baht},
+		{"2,000,001 ขึ้นไป", 175000 * Baht},
+	}
+	assert.Equal(t, expected, levels)
+}
@@ -0,0 +1,109 @@
+package tax
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// Character encodings of uploaded CSV files
+const (
+	EncodingAuto       = ""            // Detect the encoding of the file
+	EncodingUTF8       = "utf-8"       // UTF-8, with or without a byte order mark
+	EncodingWindows874 = "windows-874" // Windows-874, the Microsoft superset of TIS-620
+)
+
+// utf8BOM is the byte order mark Excel writes at the start of UTF-8 CSV files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// parseEncoding returns the encoding named by the encoding form field. TIS-620 and CP874
+// files are read as Windows-874, which encodes the same Thai characters.
+func parseEncoding(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return EncodingAuto, nil
+	case "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "windows-874", "cp874", "tis-620", "tis620":
+		return EncodingWindows874, nil
+	}
+	return "", fmt.Errorf("must be %q, %q or %q", EncodingUTF8, "tis-620", EncodingWindows874)
+}
+
+// decodeCSV returns a UTF-8 reader of a CSV file in the given encoding, without its byte
+// order mark. With EncodingAuto, files that start with a byte order mark or are valid
+// UTF-8 are read as UTF-8 and other files as Windows-874, so the whole file is scanned
+// once before it is read.
+func decodeCSV(src io.ReadSeeker, encoding string) (io.Reader, error) {
+	// Skip the byte order mark
+	head := make([]byte, len(utf8BOM))
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	start := int64(0)
+	if bytes.Equal(head[:n], utf8BOM) {
+		start = int64(n)
+		if encoding == EncodingAuto {
+			encoding = EncodingUTF8
+		}
+	}
+	if _, err := src.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if encoding == EncodingAuto {
+		valid, err := isUTF8(src)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := src.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		encoding = EncodingUTF8
+		if !valid {
+			encoding = EncodingWindows874
+		}
+	}
+
+	if encoding == EncodingWindows874 {
+		return transform.NewReader(src, charmap.Windows874.NewDecoder()), nil
+	}
+	return src, nil
+}
+
+// isUTF8 reports whether the rest of r is valid UTF-8
+func isUTF8(r io.Reader) (bool, error) {
+	buf := make([]byte, 64<<10)
+	pending := 0 // pending counts the bytes of an incomplete rune kept from the last read
+	for {
+		n, err := r.Read(buf[pending:])
+		n += pending
+		if err == io.EOF {
+			return utf8.Valid(buf[:n]), nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// Keep a rune split across reads for the next read
+		end := n
+		for i := 1; i < utf8.UTFMax && i <= n; i++ {
+			if utf8.RuneStart(buf[n-i]) {
+				if !utf8.FullRune(buf[n-i : n]) {
+					end = n - i
+				}
+				break
+			}
+		}
+		if !utf8.Valid(buf[:end]) {
+			return false, nil
+		}
+		pending = copy(buf, buf[end:n])
+	}
+}
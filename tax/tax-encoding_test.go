@@ -0,0 +1,138 @@
+package tax
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// encodeWindows874 encodes s as Windows-874
+func encodeWindows874(t *testing.T, s string) []byte {
+	t.Helper()
+
+	encoded, err := charmap.Windows874.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestParseEncoding(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{"", EncodingAuto, false},
+		{"UTF-8", EncodingUTF8, false},
+		{"tis-620", EncodingWindows874, false},
+		{"TIS620", EncodingWindows874, false},
+		{"cp874", EncodingWindows874, false},
+		{" windows-874 ", EncodingWindows874, false},
+		{"latin1", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			actual, err := parseEncoding(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestDecodeCSV(t *testing.T) {
+	thai := "ภาษีเงินได้บุคคลธรรมดา ฿1,250,000\n"
+	// A long UTF-8 file has runes split across the reads of the encoding detection
+	long := strings.Repeat(thai, 5000)
+
+	testCases := []struct {
+		name     string
+		input    []byte
+		encoding string
+		expected string
+	}{
+		{"ascii", []byte("totalIncome\n500000\n"), EncodingAuto, "totalIncome\n500000\n"},
+		{"utf-8", []byte(thai), EncodingAuto, thai},
+		{"long utf-8", []byte(long), EncodingAuto, long},
+		{"utf-8 with BOM", append([]byte("\xEF\xBB\xBF"), thai...), EncodingAuto, thai},
+		{"windows-874", encodeWindows874(t, thai), EncodingAuto, thai},
+		{"forced windows-874", encodeWindows874(t, thai), EncodingWindows874, thai},
+		{"forced windows-874 with BOM", append([]byte("\xEF\xBB\xBF"), encodeWindows874(t, thai)...), EncodingWindows874, thai},
+		{"forced utf-8", []byte(thai), EncodingUTF8, thai},
+		{"empty", nil, EncodingAuto, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader, err := decodeCSV(bytes.NewReader(tc.input), tc.encoding)
+			assert.NoError(t, err)
+			decoded, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(decoded))
+		})
+	}
+}
+
+func TestCalculateTaxFromCSVHandlerEncodings(t *testing.T) {
+	csvContent := "totalIncome,wht,donation\n\"฿1,250,000\",\"฿40,000\",20000\n"
+
+	tests := []struct {
+		name    string
+		content []byte
+		fields  map[string]string
+	}{
+		{name: "utf-8 with BOM", content: append([]byte("\xEF\xBB\xBF"), csvContent...)},
+		{name: "tis-620", content: encodeWindows874(t, csvContent)},
+		{name: "forced tis-620", content: encodeWindows874(t, csvContent), fields: map[string]string{"encoding": "tis-620"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", tt.content, tt.fields)
+			rec := httptest.NewRecorder()
+
+			err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{"taxes":[{"totalIncome":1250000,"tax":104000}]}`, rec.Body.String())
+		})
+	}
+}
+
+func TestCalculateTaxFromCSVHandlerInvalidEncoding(t *testing.T) {
+	e := echo.New()
+	req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte("totalIncome\n500000\n"), map[string]string{"encoding": "latin1"})
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"parameter":"encoding"`)
+}
+
+func TestCalculateTaxFromCSVWithBOM(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+
+	records := [][]string{{"\ufefftotalIncome", "wht"}, {"500000", "0"}}
+	results, rowErrors, err := calculateRecords(records, rules)
+	assert.NoError(t, err)
+	assert.Empty(t, rowErrors)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, 29000*Baht, results[0].Tax)
+	}
+	assert.Equal(t, "\ufefftotalIncome", records[0][0])
+}
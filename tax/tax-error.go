@@ -0,0 +1,231 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Machine-readable error codes of ErrorResponse.
+const (
+	ErrorCodeInvalidRequest = "invalid_request"
+	ErrorCodeValidation     = "validation_failed"
+	ErrorCodeInvalidFile    = "invalid_file"
+	ErrorCodeFileTooLarge   = "file_too_large"
+	ErrorCodeUnauthorized   = "unauthorized"
+	ErrorCodeNotFound       = "not_found"
+	ErrorCodeConflict       = "conflict"
+	ErrorCodeInternal       = "internal_error"
+)
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError identifies one offending input. Pointer is the JSON pointer of a
+// request body field, e.g. "/allowances/1/amount"; Parameter is the name of a
+// query or form parameter.
+type FieldError struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+	Message   string `json:"message"`
+}
+
+// ValidationError reports invalid input fields. Err is the underlying error, if any,
+// so callers can still match it with errors.Is.
+type ValidationError struct {
+	Err    error
+	Fields []FieldError
+}
+
+// Error returns the messages of the offending fields.
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		messages = append(messages, f.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the underlying error.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError returns a ValidationError for one request body field.
+func fieldError(err error, pointer string, message string) *ValidationError {
+	return &ValidationError{Err: err, Fields: []FieldError{{Pointer: pointer, Message: message}}}
+}
+
+// parameterError returns a ValidationError for one query or form parameter.
+func parameterError(err error, parameter string, message string) *ValidationError {
+	return &ValidationError{Err: err, Fields: []FieldError{{Parameter: parameter, Message: message}}}
+}
+
+// errorJSON writes an ErrorResponse with the given status.
+func errorJSON(c echo.Context, status int, code string, message string, fields ...FieldError) error {
+	return c.JSON(status, ErrorResponse{Code: code, Message: message, Fields: fields})
+}
+
+// validationFailed writes a 400 ErrorResponse for the offending fields.
+func validationFailed(c echo.Context, message string, fields ...FieldError) error {
+	return errorJSON(c, http.StatusBadRequest, ErrorCodeValidation, message, fields...)
+}
+
+// invalidParameter writes a 400 ErrorResponse for an invalid query or form parameter.
+func invalidParameter(c echo.Context, parameter string, message string) error {
+	return validationFailed(c, message, FieldError{Parameter: parameter, Message: message})
+}
+
+// internalError writes a 500 ErrorResponse.
+func internalError(c echo.Context, message string) error {
+	return errorJSON(c, http.StatusInternalServerError, ErrorCodeInternal, message)
+}
+
+// bind decodes the body of a request into v like c.Bind. encoding/json does not report
+// the field of errors returned by UnmarshalJSON methods, so the pointer of an
+// *AmountError is found by decoding the buffered body again.
+func bind(c echo.Context, v interface{}) error {
+	request := c.Request()
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+
+	err = c.Bind(v)
+	var amountErr *AmountError
+	if errors.As(err, &amountErr) && amountErr.Pointer == "" {
+		amountErr.Pointer = amountPointer(body, reflect.TypeOf(v))
+	}
+	return err
+}
+
+// amountPointer returns the JSON pointer of the first value of body that is rejected by
+// the Money or Rate field of t it is decoded into, or "" when there is none
+func amountPointer(body []byte, t reflect.Type) string {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return ""
+	}
+	return findAmountPointer(value, t, "")
+}
+
+// findAmountPointer walks a decoded JSON value along the type it is decoded into
+func findAmountPointer(value interface{}, t reflect.Type, pointer string) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(Money(0)) || t == reflect.TypeOf(Rate(0)) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return ""
+		}
+		if err := reflect.New(t).Interface().(json.Unmarshaler).UnmarshalJSON(data); err != nil {
+			return pointer
+		}
+		return ""
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, _ := value.(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			// Keys match field names case-insensitively, as in encoding/json
+			for key, v := range object {
+				if !strings.EqualFold(key, name) {
+					continue
+				}
+				if p := findAmountPointer(v, field.Type, pointer+"/"+key); p != "" {
+					return p
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		array, _ := value.([]interface{})
+		for i, v := range array {
+			if p := findAmountPointer(v, t.Elem(), fmt.Sprintf("%s/%d", pointer, i)); p != "" {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// bindFailed writes a 400 ErrorResponse for a request body that could not be decoded,
+// pointing at the offending field when the decoder, or bind, reports one.
+func bindFailed(c echo.Context, err error) error {
+	var amountErr *AmountError
+	if errors.As(err, &amountErr) && amountErr.Pointer != "" {
+		return errorJSON(c, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid request",
+			FieldError{Pointer: amountErr.Pointer, Message: amountErr.Err.Error()})
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		pointer := "/" + strings.ReplaceAll(typeErr.Field, ".", "/")
+		return errorJSON(c, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid request",
+			FieldError{Pointer: pointer, Message: "must be a " + typeErr.Type.String()})
+	}
+	return errorJSON(c, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid request")
+}
+
+// HTTPErrorHandler renders errors returned by handlers and middleware, such as
+// failed basic authentication or unknown routes, as an ErrorResponse.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	message := http.StatusText(status)
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.Code
+		if m, ok := httpErr.Message.(string); ok {
+			message = m
+		} else {
+			message = http.StatusText(status)
+		}
+	}
+
+	code := ErrorCodeInternal
+	switch {
+	case status == http.StatusUnauthorized:
+		code = ErrorCodeUnauthorized
+	case status == http.StatusNotFound || status == http.StatusMethodNotAllowed:
+		code = ErrorCodeNotFound
+	case status == http.StatusConflict:
+		code = ErrorCodeConflict
+	case status < http.StatusInternalServerError:
+		code = ErrorCodeInvalidRequest
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = errorJSON(c, status, code, message)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
@@ -0,0 +1,111 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateTaxHandlerErrorResponse(t *testing.T) {
+	testCases := []struct {
+		name             string
+		requestBody      string
+		expectedCode     string
+		expectedPointers []string
+	}{
+		{
+			name:             "Malformed field type",
+			requestBody:      `{"totalIncome": "a lot"}`,
+			expectedCode:     ErrorCodeInvalidRequest,
+			expectedPointers: []string{"/totalIncome"},
+		},
+		{
+			name:             "Malformed allowance amount",
+			requestBody:      `{"totalIncome": 500000.0, "allowances": [{"allowanceType": "donation", "amount": 0}, {"allowanceType": "donation", "amount": "abc"}]}`,
+			expectedCode:     ErrorCodeInvalidRequest,
+			expectedPointers: []string{"/allowances/1/amount"},
+		},
+		{
+			name:             "Wrong type for allowances",
+			requestBody:      `{"totalIncome": 500000.0, "allowances": {"allowanceType": "donation"}}`,
+			expectedCode:     ErrorCodeInvalidRequest,
+			expectedPointers: []string{"/allowances"},
+		},
+		{
+			name:             "WHT exceeding total income",
+			requestBody:      `{"totalIncome": 500000.0, "wht": 600000.0}`,
+			expectedCode:     ErrorCodeValidation,
+			expectedPointers: []string{"/wht"},
+		},
+		{
+			name:             "Unknown tax year",
+			requestBody:      `{"totalIncome": 500000.0, "taxYear": 2400}`,
+			expectedCode:     ErrorCodeValidation,
+			expectedPointers: []string{"/taxYear"},
+		},
+		{
+			name:             "Negative second allowance",
+			requestBody:      `{"totalIncome": 500000.0, "allowances": [{"allowanceType": "donation", "amount": 0}, {"allowanceType": "k-receipt", "amount": -1}]}`,
+			expectedCode:     ErrorCodeValidation,
+			expectedPointers: []string{"/allowances/1/amount"},
+		},
+		{
+			name:             "Amount beyond MaxMoney",
+			requestBody:      `{"totalIncome": 100000000000000, "wht": 0}`,
+			expectedCode:     ErrorCodeInvalidRequest,
+			expectedPointers: []string{"/totalIncome"},
+		},
+		{
+			name:             "Sum of incomes beyond MaxMoney",
+			requestBody:      `{"incomes": [{"incomeType": "40(1)", "amount": 1000000000000}, {"incomeType": "40(8)", "amount": 1}]}`,
+			expectedCode:     ErrorCodeValidation,
+			expectedPointers: []string{"/incomes"},
+		},
+		{
+			name:             "Unknown allowance type",
+			requestBody:      `{"totalIncome": 500000.0, "allowances": [{"allowanceType": "lottery", "amount": 1000}]}`,
+			expectedCode:     ErrorCodeValidation,
+			expectedPointers: []string{"/allowances/0/allowanceType"},
+		},
+	}
+
+	e := echo.New()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tax/calculations", bytes.NewBufferString(tc.requestBody))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			err := newTestHandler(t).CalculateTaxHandler(e.NewContext(req, rec))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			var response ErrorResponse
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+			assert.Equal(t, tc.expectedCode, response.Code)
+			assert.NotEmpty(t, response.Message)
+
+			var pointers []string
+			for _, f := range response.Fields {
+				pointers = append(pointers, f.Pointer)
+			}
+			assert.Equal(t, tc.expectedPointers, pointers)
+		})
+	}
+}
+
+func TestHTTPErrorHandler(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+	rec := httptest.NewRecorder()
+
+	HTTPErrorHandler(echo.ErrUnauthorized, e.NewContext(req, rec))
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.JSONEq(t, `{"code":"unauthorized","message":"Unauthorized"}`, rec.Body.String())
+}
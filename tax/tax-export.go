@@ -0,0 +1,398 @@
+package tax
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/xuri/excelize/v2"
+)
+
+// Formats of bulk calculation results
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// MIME types of the CSV and XLSX result formats
+const (
+	MIMETextCSV         = "text/csv"
+	MIMEApplicationXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// resultSheetName is the name of the worksheet of XLSX results
+const resultSheetName = "Taxes"
+
+// negotiateFormat selects the result format from the format parameter or, when it is
+// empty, from the supported media type of the Accept header with the highest q-value.
+func negotiateFormat(format string, accept string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case FormatJSON, FormatCSV, FormatXLSX:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("must be %q, %q or %q", FormatJSON, FormatCSV, FormatXLSX)
+	}
+
+	// The first of the media types with the highest q-value wins; q=0 rules a type out
+	best, bestQ := FormatJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		var candidate string
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case MIMETextCSV:
+			candidate = FormatCSV
+		case MIMEApplicationXLSX:
+			candidate = FormatXLSX
+		case echo.MIMEApplicationJSON:
+			candidate = FormatJSON
+		default:
+			continue
+		}
+		if q := acceptQuality(params[1:]); q > bestQ {
+			best, bestQ = candidate, q
+		}
+	}
+	return best, nil
+}
+
+// acceptQuality returns the q-value of the parameters of an Accept media range, 1 when
+// it has none and 0 when it is invalid
+func acceptQuality(params []string) float64 {
+	for _, param := range params {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
+// resultRow is one data record of a tax file with either its result or its error
+type resultRow struct {
+	row    int // row is the 1-based record number, counting the header
+	record []string
+	result *TaxCalculation
+	err    *CSVRowError
+}
+
+// resultWriter writes bulk calculation results one row at a time
+type resultWriter interface {
+	// WriteRow writes the result or the error of one row.
+	WriteRow(row resultRow) error
+	// Close finishes the document.
+	Close() error
+}
+
+// resultMediaType returns the content type and the file name of results in the given format
+func resultMediaType(format string) (string, string) {
+	switch format {
+	case FormatCSV:
+		return MIMETextCSV + "; charset=utf-8", "taxes.csv"
+	case FormatXLSX:
+		return MIMEApplicationXLSX, "taxes.xlsx"
+	}
+	return echo.MIMEApplicationJSON, "taxes.json"
+}
+
+// newResultWriter returns the writer of results in the given format. Results carry their
+// detail only when detail is set; invalid rows are written only when withErrors is set.
+func newResultWriter(w io.Writer, format string, layout csvLayout, rules RuleSet, detail bool, withErrors bool) (resultWriter, error) {
+	columns := newResultColumns(layout, rules, withErrors)
+	switch format {
+	case FormatCSV:
+		return newCSVResultWriter(w, columns)
+	case FormatXLSX:
+		return newXLSXResultWriter(w, columns)
+	}
+	return newJSONResultWriter(w, detail, withErrors)
+}
+
+// writeResultHeader commits a 200 response for results in the given format. CSV and XLSX
+// results are sent as attachments.
+func writeResultHeader(c echo.Context, format string) {
+	contentType, filename := resultMediaType(format)
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	if format != FormatJSON {
+		header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	c.Response().WriteHeader(http.StatusOK)
+}
+
+// jsonResultWriter writes results as {"taxes": [...]}. With errors, every result carries its
+// row number and the invalid rows follow the results as "errors".
+type jsonResultWriter struct {
+	w          *bufio.Writer
+	detail     bool
+	withErrors bool
+	count      int
+	rowErrors  []CSVRowError
+}
+
+// newJSONResultWriter starts a JSON document of results
+func newJSONResultWriter(w io.Writer, detail bool, withErrors bool) (*jsonResultWriter, error) {
+	writer := &jsonResultWriter{w: bufio.NewWriter(w), detail: detail, withErrors: withErrors}
+	_, err := writer.w.WriteString(`{"taxes":[`)
+	return writer, err
+}
+
+// WriteRow writes the result of a valid row and keeps an invalid row for the errors.
+func (w *jsonResultWriter) WriteRow(row resultRow) error {
+	if row.err != nil {
+		if w.withErrors {
+			w.rowErrors = append(w.rowErrors, *row.err)
+		}
+		return nil
+	}
+
+	result := *row.result
+	if !w.detail {
+		result = result.Lean()
+	}
+	var item interface{} = result
+	if w.withErrors {
+		item = CSVRowResult{Row: row.row, TaxCalculation: result}
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	if w.count > 0 {
+		if err := w.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	w.count++
+	_, err = w.w.Write(data)
+	return err
+}
+
+// Close writes the errors and ends the document.
+func (w *jsonResultWriter) Close() error {
+	if _, err := w.w.WriteString("]"); err != nil {
+		return err
+	}
+	if w.withErrors {
+		rowErrors := w.rowErrors
+		if rowErrors == nil {
+			rowErrors = []CSVRowError{}
+		}
+		data, err := json.Marshal(rowErrors)
+		if err != nil {
+			return err
+		}
+		if _, err := w.w.WriteString(`,"errors":`); err != nil {
+			return err
+		}
+		if _, err := w.w.Write(data); err != nil {
+			return err
+		}
+	}
+	if _, err := w.w.WriteString("}\n"); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// resultColumns are the columns of bulk calculation results written to CSV and XLSX files:
+// the input columns followed by tax, taxRefund, one column per tax band and, when
+// invalid rows are reported, an error column
+type resultColumns struct {
+	columns []string // columns holds the names of the input columns
+	bands   []string // bands holds the labels of the tax bands
+	errors  bool     // errors reports whether invalid rows are written with an error column
+	decimal string   // decimal is the decimal separator of the input amounts
+}
+
+// newResultColumns returns the result columns of a file layout
+func newResultColumns(layout csvLayout, rules RuleSet, withErrors bool) resultColumns {
+	columns := resultColumns{columns: layout.columns, errors: withErrors, decimal: layout.decimal}
+	for _, bracket := range rules.Brackets {
+		columns.bands = append(columns.bands, bracket.Label())
+	}
+	return columns
+}
+
+// header returns the column names
+func (t resultColumns) header() []string {
+	header := append([]string{}, t.columns...)
+	header = append(header, "tax", "taxRefund")
+	header = append(header, t.bands...)
+	if t.errors {
+		header = append(header, "error")
+	}
+	return header
+}
+
+// skip reports whether a row is left out of the results
+func (t resultColumns) skip(row resultRow) bool {
+	return row.result == nil && !t.errors
+}
+
+// cells returns the values of one row: input values as strings, computed
+// amounts as Money and empty cells as nil
+func (t resultColumns) cells(row resultRow) []interface{} {
+	cells := make([]interface{}, 0, len(t.columns)+2+len(t.bands)+1)
+	for i := range t.columns {
+		var value interface{}
+		if i < len(row.record) {
+			value = strings.TrimSpace(row.record[i])
+		}
+		cells = append(cells, value)
+	}
+
+	if row.result != nil {
+		cells = append(cells, row.result.Tax)
+		var levels []TaxLevel
+		if row.result.TaxCalculationDetail != nil {
+			cells = append(cells, row.result.TaxRefund)
+			levels = row.result.TaxLevel
+		} else {
+			cells = append(cells, nil)
+		}
+		for _, band := range t.bands {
+			var value interface{}
+			for _, level := range levels {
+				if level.Level == band {
+					value = level.Tax
+					break
+				}
+			}
+			cells = append(cells, value)
+		}
+	} else {
+		for i := 0; i < 2+len(t.bands); i++ {
+			cells = append(cells, nil)
+		}
+	}
+
+	if t.errors {
+		var value interface{}
+		if row.err != nil {
+			value = row.err.Error()
+		}
+		cells = append(cells, value)
+	}
+	return cells
+}
+
+// csvResultWriter writes results as a CSV file with amounts in baht
+type csvResultWriter struct {
+	columns resultColumns
+	writer  *csv.Writer
+}
+
+// newCSVResultWriter starts a CSV file of results with its header row
+func newCSVResultWriter(w io.Writer, columns resultColumns) (*csvResultWriter, error) {
+	writer := &csvResultWriter{columns: columns, writer: csv.NewWriter(w)}
+	return writer, writer.writer.Write(columns.header())
+}
+
+// WriteRow writes one row.
+func (w *csvResultWriter) WriteRow(row resultRow) error {
+	if w.columns.skip(row) {
+		return nil
+	}
+	cells := w.columns.cells(row)
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		switch value := cell.(type) {
+		case string:
+			record[i] = value
+		case Money:
+			record[i] = formatFixed(int64(value), 2)
+		}
+	}
+	return w.writer.Write(record)
+}
+
+// Close flushes the file.
+func (w *csvResultWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// xlsxResultWriter writes results as an XLSX workbook with amounts as numbers in baht.
+// Rows are streamed to a temporary file by excelize and the workbook is written on Close.
+type xlsxResultWriter struct {
+	w       io.Writer
+	columns resultColumns
+	file    *excelize.File
+	stream  *excelize.StreamWriter
+	count   int
+}
+
+// newXLSXResultWriter starts a workbook of results with its header row
+func newXLSXResultWriter(w io.Writer, columns resultColumns) (*xlsxResultWriter, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName(f.GetSheetName(0), resultSheetName); err != nil {
+		f.Close()
+		return nil, err
+	}
+	stream, err := f.NewStreamWriter(resultSheetName)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	header := columns.header()
+	values := make([]interface{}, len(header))
+	for i, name := range header {
+		values[i] = name
+	}
+	if err := stream.SetRow("A1", values); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &xlsxResultWriter{w: w, columns: columns, file: f, stream: stream, count: 1}, nil
+}
+
+// WriteRow writes one row.
+func (w *xlsxResultWriter) WriteRow(row resultRow) error {
+	if w.columns.skip(row) {
+		return nil
+	}
+	cells := w.columns.cells(row)
+	for i, cell := range cells {
+		switch value := cell.(type) {
+		case string:
+			// Keep amounts of the input columns numeric so they can be used in formulas
+			if amount, err := ParseAmount(value, w.columns.decimal); err == nil {
+				cells[i] = amount.Float64()
+			}
+		case Money:
+			cells[i] = value.Float64()
+		}
+	}
+
+	w.count++
+	cell, err := excelize.CoordinatesToCellName(1, w.count)
+	if err != nil {
+		return err
+	}
+	return w.stream.SetRow(cell, cells)
+}
+
+// Close writes the workbook.
+func (w *xlsxResultWriter) Close() error {
+	defer w.file.Close()
+	if err := w.stream.Flush(); err != nil {
+		return err
+	}
+	return w.file.Write(w.w)
+}
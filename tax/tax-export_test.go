@@ -0,0 +1,119 @@
+package tax
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		accept   string
+		expected string
+		wantErr  bool
+	}{
+		{name: "default", expected: FormatJSON},
+		{name: "format parameter", format: "CSV", expected: FormatCSV},
+		{name: "format parameter wins over Accept", format: "json", accept: MIMETextCSV, expected: FormatJSON},
+		{name: "Accept csv", accept: "text/csv;q=0.9, */*", expected: FormatCSV},
+		{name: "Accept xlsx", accept: MIMEApplicationXLSX, expected: FormatXLSX},
+		{name: "Accept unsupported", accept: "text/html", expected: FormatJSON},
+		{name: "Accept q-values", accept: "text/csv;q=0.1, application/json", expected: FormatJSON},
+		{name: "Accept highest q-value", accept: "application/json;q=0.5, " + MIMEApplicationXLSX + ";q=0.8", expected: FormatXLSX},
+		{name: "Accept q=0", accept: "text/csv;q=0", expected: FormatJSON},
+		{name: "invalid format", format: "pdf", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := negotiateFormat(tt.format, tt.accept)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, format)
+		})
+	}
+}
+
+func TestCalculateTaxFromCSVHandlerCSVDownload(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte(csvContent), nil)
+	req.Header.Set(echo.HeaderAccept, MIMETextCSV)
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, `attachment; filename="taxes.csv"`, rec.Header().Get(echo.HeaderContentDisposition))
+	assert.Equal(t, `totalIncome,wht,donation,tax,taxRefund,"0-150,000","150,001-500,000","500,001-1,000,000","1,000,001-2,000,000","2,000,001 ขึ้นไป"
+500000,0,0,29000,0,0,29000,0,0,0
+600000,40000,20000,0,2000,0,35000,3000,0,0
+`, rec.Body.String())
+}
+
+func TestCalculateTaxFromCSVHandlerCSVDownloadWithErrors(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,k-receipt\n500000,abc\n500000,\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv?format=csv", "taxes.csv", []byte(csvContent), map[string]string{"onError": "continue"})
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `totalIncome,k-receipt,tax,taxRefund,"0-150,000","150,001-500,000","500,001-1,000,000","1,000,001-2,000,000","2,000,001 ขึ้นไป",error
+500000,abc,,,,,,,,"row 2, column k-receipt: invalid k-receipt"
+500000,,29000,0,0,29000,0,0,0,
+`, rec.Body.String())
+}
+
+func TestCalculateTaxFromCSVHandlerXLSXDownload(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,0,0\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv?format=xlsx", "taxes.csv", []byte(csvContent), nil)
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, MIMEApplicationXLSX, rec.Header().Get(echo.HeaderContentType))
+
+	f, err := excelize.OpenReader(bytes.NewReader(rec.Body.Bytes()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows(resultSheetName)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"totalIncome", "wht", "donation", "tax", "taxRefund", "0-150,000", "150,001-500,000", "500,001-1,000,000", "1,000,001-2,000,000", "2,000,001 ขึ้นไป"},
+		{"500000", "0", "0", "29000", "0", "0", "29000", "0", "0", "0"},
+	}, rows)
+
+	cellType, err := f.GetCellType(resultSheetName, "D2")
+	assert.NoError(t, err)
+	assert.NotEqual(t, excelize.CellTypeSharedString, cellType)
+}
+
+func TestCalculateTaxFromCSVHandlerInvalidFormat(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,0,0\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv?format=pdf", "taxes.csv", []byte(csvContent), nil)
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"parameter":"format"`)
+}
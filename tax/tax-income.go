@@ -0,0 +1,188 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownIncome is returned when an incomeType is not a category of assessable income.
+var ErrUnknownIncome = errors.New("unknown income type")
+
+// ErrInvalidIncome is returned when an income amount is rejected.
+var ErrInvalidIncome = errors.New("invalid income amount")
+
+// Income represents an amount of assessable income of one category of section 40 of
+// the Revenue Code, such as "40(1)" for salary.
+type Income struct {
+	IncomeType string `json:"incomeType"`
+	Amount     Money  `json:"amount"`
+}
+
+// IncomeSummary represents the gross income of one category, its standard expense
+// deduction and the income net of expenses.
+type IncomeSummary struct {
+	IncomeType       string `json:"incomeType"`
+	GrossIncome      Money  `json:"grossIncome"`
+	ExpenseDeduction Money  `json:"expenseDeduction"`
+	NetIncome        Money  `json:"netIncome"`
+}
+
+// IncomeCategory represents the standard expense deduction of a category of assessable
+// income: ExpenseRate of the income, limited to ExpenseCap when it is not 0. Categories
+// with the same CapGroup share one cap.
+type IncomeCategory struct {
+	Type        string
+	ExpenseRate Rate
+	ExpenseCap  Money
+	CapGroup    string
+}
+
+// incomeCategories are the categories of assessable income with their standard expense
+// deductions. 40(6) uses the 30% rate of liberal professions other than medicine.
+var incomeCategories = map[string]IncomeCategory{
+	"40(1)": {Type: "40(1)", ExpenseRate: 50 * Percent, ExpenseCap: 100000 * Baht, CapGroup: "40(1)-40(2)"}, // salary and wages
+	"40(2)": {Type: "40(2)", ExpenseRate: 50 * Percent, ExpenseCap: 100000 * Baht, CapGroup: "40(1)-40(2)"}, // fees and commissions
+	"40(3)": {Type: "40(3)", ExpenseRate: 50 * Percent, ExpenseCap: 100000 * Baht},                          // royalties
+	"40(4)": {Type: "40(4)"},                                                                                // interest and dividends
+	"40(5)": {Type: "40(5)", ExpenseRate: 30 * Percent},                                                     // rent
+	"40(6)": {Type: "40(6)", ExpenseRate: 30 * Percent},                                                     // professional fees
+	"40(7)": {Type: "40(7)", ExpenseRate: 60 * Percent},                                                     // contracts of work
+	"40(8)": {Type: "40(8)", ExpenseRate: 60 * Percent},                                                     // business
+}
+
+// LookupIncomeCategory returns the category of an income type.
+func LookupIncomeCategory(incomeType string) (IncomeCategory, bool) {
+	category, ok := incomeCategories[incomeType]
+	return category, ok
+}
+
+// grossIncome returns the gross income of a request: the sum of its incomes or, without
+// incomes, its total income
+func (r CalculationRequest) grossIncome() Money {
+	if len(r.Incomes) == 0 {
+		return r.TotalIncome
+	}
+	var gross Money
+	for _, income := range r.Incomes {
+		gross += income.Amount
+	}
+	return gross
+}
+
+// salaryIncome returns the salary and wages of section 40(1) of a request or, without
+// incomes, its total income
+func (r CalculationRequest) salaryIncome() Money {
+	if len(r.Incomes) == 0 {
+		return r.TotalIncome
+	}
+	var salary Money
+	for _, income := range r.Incomes {
+		if income.IncomeType == salaryIncomeType {
+			salary += income.Amount
+		}
+	}
+	return salary
+}
+
+// validateIncome checks the income at index n of a request
+func validateIncome(n int, income Income) error {
+	if _, ok := LookupIncomeCategory(income.IncomeType); !ok {
+		err := fmt.Errorf("%w: %q", ErrUnknownIncome, income.IncomeType)
+		return fieldError(err, fmt.Sprintf("/incomes/%d/incomeType", n), err.Error())
+	}
+	if income.Amount < 0 {
+		err := fmt.Errorf("%w: %s must not be negative", ErrInvalidIncome, income.IncomeType)
+		return fieldError(err, fmt.Sprintf("/incomes/%d/amount", n), err.Error())
+	}
+	return nil
+}
+
+// applyIncomes validates every income, sums the amounts per income type and returns the
+// summary of each type in request order. Shared expense caps are used up in request order.
+func applyIncomes(incomes []Income) ([]IncomeSummary, error) {
+	var summaries []IncomeSummary
+	index := map[string]int{}
+	for n, income := range incomes {
+		if err := validateIncome(n, income); err != nil {
+			return nil, err
+		}
+
+		i, ok := index[income.IncomeType]
+		if !ok {
+			i = len(summaries)
+			index[income.IncomeType] = i
+			summaries = append(summaries, IncomeSummary{IncomeType: income.IncomeType})
+		}
+		summaries[i].GrossIncome += income.Amount
+	}
+
+	// Deduct the expenses of every type within the remaining cap of its group
+	used := map[string]Money{}
+	for i, summary := range summaries {
+		category, _ := LookupIncomeCategory(summary.IncomeType)
+		expense := summary.GrossIncome.MulRate(category.ExpenseRate)
+		if category.ExpenseCap > 0 {
+			group := category.CapGroup
+			if group == "" {
+				group = category.Type
+			}
+			expense = expense.Min(category.ExpenseCap - used[group])
+			used[group] += expense
+		}
+		summaries[i].ExpenseDeduction = expense
+		summaries[i].NetIncome = summary.GrossIncome - expense
+	}
+	return summaries, nil
+}
+
+// Methods of calculating the tax before withholding tax
+const (
+	TaxMethodProgressive = "progressive" // The tax of the brackets on the taxable income
+	TaxMethodMinimum     = "minimum"     // 0.5% of the gross income other than salary
+)
+
+// Parameters of the minimum tax of section 48(2) of the Revenue Code
+const (
+	minimumTaxRate      = Percent / 2   // minimumTaxRate is 0.5% of the gross income other than 40(1)
+	minimumTaxThreshold = 120000 * Baht // minimumTaxThreshold is the income other than 40(1) from which the minimum tax is compared
+	minimumTaxExemption = 5000 * Baht   // minimumTaxExemption is the minimum tax up to which only the progressive tax is paid
+	salaryIncomeType    = "40(1)"       // salaryIncomeType is the category excluded from the minimum tax
+)
+
+// TaxMethod reports how the tax before withholding tax was calculated: the higher of the
+// progressive tax and the minimum tax is paid.
+type TaxMethod struct {
+	Method         string `json:"method"`
+	ProgressiveTax Money  `json:"progressiveTax"`
+	MinimumTax     Money  `json:"minimumTax"`
+}
+
+// compareMinimumTax compares the progressive tax with the minimum tax of 0.5% of the gross
+// income other than salary, which is paid instead when it is higher. The comparison is
+// made when that income is 120,000 or more; a minimum tax of 5,000 or less is exempt.
+// It returns nil when no comparison is made.
+func compareMinimumTax(incomes []IncomeSummary, progressiveTax Money) *TaxMethod {
+	var nonSalary Money
+	for _, summary := range incomes {
+		if summary.IncomeType != salaryIncomeType {
+			nonSalary += summary.GrossIncome
+		}
+	}
+	if nonSalary < minimumTaxThreshold {
+		return nil
+	}
+
+	method := &TaxMethod{Method: TaxMethodProgressive, ProgressiveTax: progressiveTax, MinimumTax: nonSalary.MulRate(minimumTaxRate)}
+	if method.MinimumTax > minimumTaxExemption && method.MinimumTax > progressiveTax {
+		method.Method = TaxMethodMinimum
+	}
+	return method
+}
+
+// Tax returns the tax of the applied method.
+func (m TaxMethod) Tax() Money {
+	if m.Method == TaxMethodMinimum {
+		return m.MinimumTax
+	}
+	return m.ProgressiveTax
+}
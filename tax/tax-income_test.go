@@ -0,0 +1,127 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyIncomes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		incomes  []Income
+		expected []IncomeSummary
+		pointer  string
+	}{
+		{
+			name:     "salary below the cap",
+			incomes:  []Income{{IncomeType: "40(1)", Amount: 120000 * Baht}},
+			expected: []IncomeSummary{{IncomeType: "40(1)", GrossIncome: 120000 * Baht, ExpenseDeduction: 60000 * Baht, NetIncome: 60000 * Baht}},
+		},
+		{
+			name:    "salary and fees share the cap",
+			incomes: []Income{{IncomeType: "40(1)", Amount: 150000 * Baht}, {IncomeType: "40(2)", Amount: 100000 * Baht}},
+			expected: []IncomeSummary{
+				{IncomeType: "40(1)", GrossIncome: 150000 * Baht, ExpenseDeduction: 75000 * Baht, NetIncome: 75000 * Baht},
+				{IncomeType: "40(2)", GrossIncome: 100000 * Baht, ExpenseDeduction: 25000 * Baht, NetIncome: 75000 * Baht},
+			},
+		},
+		{
+			name:    "uncapped categories",
+			incomes: []Income{{IncomeType: "40(5)", Amount: 100000 * Baht}, {IncomeType: "40(6)", Amount: 100000 * Baht}, {IncomeType: "40(8)", Amount: 1000000 * Baht}},
+			expected: []IncomeSummary{
+				{IncomeType: "40(5)", GrossIncome: 100000 * Baht, ExpenseDeduction: 30000 * Baht, NetIncome: 70000 * Baht},
+				{IncomeType: "40(6)", GrossIncome: 100000 * Baht, ExpenseDeduction: 30000 * Baht, NetIncome: 70000 * Baht},
+				{IncomeType: "40(8)", GrossIncome: 1000000 * Baht, ExpenseDeduction: 600000 * Baht, NetIncome: 400000 * Baht},
+			},
+		},
+		{
+			name:    "amounts of one type are summed",
+			incomes: []Income{{IncomeType: "40(4)", Amount: 1000 * Baht}, {IncomeType: "40(4)", Amount: 500 * Baht}},
+			expected: []IncomeSummary{
+				{IncomeType: "40(4)", GrossIncome: 1500 * Baht, ExpenseDeduction: 0, NetIncome: 1500 * Baht},
+			},
+		},
+		{name: "unknown type", incomes: []Income{{IncomeType: "40(9)", Amount: 1000 * Baht}}, pointer: "/incomes/0/incomeType"},
+		{name: "negative amount", incomes: []Income{{IncomeType: "40(1)", Amount: 0}, {IncomeType: "40(1)", Amount: -1}}, pointer: "/incomes/1/amount"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := applyIncomes(tc.incomes)
+			if tc.pointer != "" {
+				var validationErr *ValidationError
+				if assert.ErrorAs(t, err, &validationErr) {
+					assert.Equal(t, tc.pointer, validationErr.Fields[0].Pointer)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestCalculateWithIncomes(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+
+	request := CalculationRequest{Incomes: []Income{
+		{IncomeType: "40(1)", Amount: 600000 * Baht},
+		{IncomeType: "40(2)", Amount: 100000 * Baht},
+		{IncomeType: "40(8)", Amount: 200000 * Baht},
+	}}
+	response, err := rules.Calculate(request)
+	assert.NoError(t, err)
+	assert.Equal(t, 900000*Baht, response.GrossIncome)
+	assert.Equal(t, 220000*Baht, response.ExpenseDeduction)
+	assert.Equal(t, 680000*Baht, response.NetIncome)
+	assert.Equal(t, 620000*Baht, response.TaxableIncome)
+	assert.Equal(t, 53000*Baht, response.Tax)
+	assert.Len(t, response.Incomes, 3)
+
+	// A total income must match the incomes
+	request.TotalIncome = 900000 * Baht
+	_, err = rules.Calculate(request)
+	assert.NoError(t, err)
+	request.TotalIncome = 800000 * Baht
+	_, err = rules.Calculate(request)
+	var validationErr *ValidationError
+	if assert.ErrorAs(t, err, &validationErr) {
+		assert.Equal(t, "/totalIncome", validationErr.Fields[0].Pointer)
+	}
+}
+
+func TestCalculateTaxHandlerWithIncomes(t *testing.T) {
+	e := echo.New()
+	body := `{
+		"incomes": [{"incomeType": "40(1)", "amount": 600000}, {"incomeType": "40(8)", "amount": 100000}],
+		"wht": 50000,
+		"allowances": []
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/tax/calculations", bytes.NewBufferString(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response CalculationResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, 700000*Baht, response.GrossIncome)
+	assert.Equal(t, 160000*Baht, response.ExpenseDeduction)
+	assert.Equal(t, 540000*Baht, response.NetIncome)
+	assert.Equal(t, []IncomeSummary{
+		{IncomeType: "40(1)", GrossIncome: 600000 * Baht, ExpenseDeduction: 100000 * Baht, NetIncome: 500000 * Baht},
+		{IncomeType: "40(8)", GrossIncome: 100000 * Baht, ExpenseDeduction: 60000 * Baht, NetIncome: 40000 * Baht},
+	}, response.Incomes)
+	assert.Equal(t, 480000*Baht, response.TaxableIncome)
+	assert.Equal(t, 0*Baht, response.Tax)
+	assert.Equal(t, 17000*Baht, response.TaxRefund)
+}
@@ -0,0 +1,298 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// PostgresJobStore is a JobStore storing files and results in chunks in PostgreSQL.
+type PostgresJobStore struct {
+	db *sql.DB
+}
+
+// jobChunkSize is the size of the chunks of uploaded files and results
+const jobChunkSize = 1 << 20
+
+// Kinds of the chunks of a job
+const (
+	jobChunkInput  = "input"
+	jobChunkResult = "result"
+)
+
+// writeChunks stores everything read from r as the chunks of a job
+func writeChunks(ctx context.Context, tx *sql.Tx, id string, kind string, r io.Reader) error {
+	buf := make([]byte, jobChunkSize)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			_, execErr := tx.ExecContext(ctx, `INSERT INTO tax_job_chunks (job_id, kind, seq, data) VALUES ($1, $2, $3, $4)`, id, kind, seq, buf[:n])
+			if execErr != nil {
+				return execErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// chunkReader reads the chunks of a job one at a time
+type chunkReader struct {
+	ctx  context.Context
+	db   *sql.DB
+	id   string
+	kind string
+	seq  int
+	buf  []byte
+	done bool
+}
+
+// Read reads from the current chunk, fetching the next one when it is used up.
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.db.QueryRowContext(r.ctx, `SELECT data FROM tax_job_chunks WHERE job_id = $1 AND kind = $2 AND seq = $3`, r.id, r.kind, r.seq).Scan(&r.buf)
+		if errors.Is(err, sql.ErrNoRows) {
+			r.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.seq++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close does nothing; every chunk is fetched with its own query.
+func (r *chunkReader) Close() error {
+	return nil
+}
+
+// NewPostgresJobStore creates a PostgresJobStore.
+func NewPostgresJobStore(db *sql.DB) *PostgresJobStore {
+	return &PostgresJobStore{db: db}
+}
+
+// jobColumns are the columns read by scanJob
+const jobColumns = `id, status, options, rows_done, rows_failed, error, attempt, created_at, started_at, finished_at, updated_at`
+
+// scanJob reads the jobColumns of a row followed by the extra destinations
+func scanJob(row interface{ Scan(...interface{}) error }, extra ...interface{}) (Job, error) {
+	var job Job
+	var options []byte
+	var jobErr []byte
+	var startedAt, finishedAt sql.NullTime
+	dest := []interface{}{&job.ID, &job.Status, &options, &job.RowsDone, &job.RowsFailed, &jobErr, &job.Attempt, &job.CreatedAt, &startedAt, &finishedAt, &job.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return Job{}, err
+	}
+
+	if err := json.Unmarshal(options, &job.Options); err != nil {
+		return Job{}, err
+	}
+	if jobErr != nil {
+		job.Error = &ErrorResponse{}
+		if err := json.Unmarshal(jobErr, job.Error); err != nil {
+			return Job{}, err
+		}
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
+
+// CreateJob stores a new job with its uploaded file.
+func (s *PostgresJobStore) CreateJob(ctx context.Context, job Job, input io.Reader) error {
+	options, err := json.Marshal(job.Options)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO tax_jobs (id, status, options, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		job.ID, job.Status, options, job.CreatedAt, job.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if err := writeChunks(ctx, tx, job.ID, jobChunkInput, input); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetJob returns the job with the given ID.
+func (s *PostgresJobStore) GetJob(ctx context.Context, id string) (Job, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM tax_jobs WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, ErrJobNotFound
+	}
+	return job, err
+}
+
+// ClaimJob marks the oldest runnable job not locked by another instance as running.
+func (s *PostgresJobStore) ClaimJob(ctx context.Context, now time.Time, staleBefore time.Time) (Job, bool, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `
+		UPDATE tax_jobs
+		SET status = 'running', attempt = attempt + 1, rows_done = 0, rows_failed = 0, started_at = $1, updated_at = $1
+		WHERE id = (
+			SELECT id FROM tax_jobs
+			WHERE status = 'queued' OR (status = 'running' AND updated_at < $2)
+			ORDER BY created_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		now, staleBefore,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+// OpenInput returns a reader of the chunks of the uploaded file of an unfinished job.
+func (s *PostgresJobStore) OpenInput(ctx context.Context, id string) (io.ReadCloser, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT true FROM tax_jobs WHERE id = $1 AND status IN ('queued', 'running')`, id).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{ctx: ctx, db: s.db, id: id, kind: jobChunkInput}, nil
+}
+
+// UpdateProgress records the progress of a running attempt.
+func (s *PostgresJobStore) UpdateProgress(ctx context.Context, id string, attempt int, rowsDone int, rowsFailed int, now time.Time) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE tax_jobs SET rows_done = $3, rows_failed = $4, updated_at = $5
+		WHERE id = $1 AND attempt = $2 AND status = 'running'`,
+		id, attempt, rowsDone, rowsFailed, now,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// FinishJob records the final state of a running attempt and drops its uploaded file.
+func (s *PostgresJobStore) FinishJob(ctx context.Context, job Job, result io.Reader) (bool, error) {
+	var jobErr []byte
+	if job.Error != nil {
+		var err error
+		if jobErr, err = json.Marshal(job.Error); err != nil {
+			return false, err
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		UPDATE tax_jobs
+		SET status = $3, rows_done = $4, rows_failed = $5, error = $6, finished_at = $7, updated_at = $8
+		WHERE id = $1 AND attempt = $2 AND status = 'running'`,
+		job.ID, job.Attempt, job.Status, job.RowsDone, job.RowsFailed, jobErr, job.FinishedAt, job.UpdatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return false, err
+	}
+
+	if job.Status == JobSucceeded {
+		if err := writeChunks(ctx, tx, job.ID, jobChunkResult, result); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tax_job_chunks WHERE job_id = $1 AND kind = $2`, job.ID, jobChunkInput); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// CancelJob cancels a queued or running job and drops its uploaded file.
+func (s *PostgresJobStore) CancelJob(ctx context.Context, id string, now time.Time) (Job, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Job{}, false, err
+	}
+	defer tx.Rollback()
+	job, err := scanJob(tx.QueryRowContext(ctx, `
+		UPDATE tax_jobs SET status = 'cancelled', finished_at = $2, updated_at = $2
+		WHERE id = $1 AND status IN ('queued', 'running')
+		RETURNING `+jobColumns,
+		id, now,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		// The job is unknown or has already finished
+		tx.Rollback()
+		job, err := s.GetJob(ctx, id)
+		return job, false, err
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tax_job_chunks WHERE job_id = $1 AND kind = $2`, id, jobChunkInput); err != nil {
+		return Job{}, false, err
+	}
+	return job, true, tx.Commit()
+}
+
+// GetResult returns a reader of the chunks of the result of a succeeded job.
+func (s *PostgresJobStore) GetResult(ctx context.Context, id string) (io.ReadCloser, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT true FROM tax_jobs WHERE id = $1 AND status = 'succeeded'`, id).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{ctx: ctx, db: s.db, id: id, kind: jobChunkResult}, nil
+}
+
+// DeleteJobs deletes the jobs finished before finishedBefore with their chunks.
+func (s *PostgresJobStore) DeleteJobs(ctx context.Context, finishedBefore time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM tax_jobs
+		WHERE status IN ('succeeded', 'failed', 'cancelled') AND finished_at < $1`,
+		finishedBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,401 @@
+package tax
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Timing of the job runner
+const (
+	// jobPollInterval is how often the runner looks for jobs submitted to other instances
+	jobPollInterval = 5 * time.Second
+	// jobHeartbeatInterval is how often a running job records its progress
+	jobHeartbeatInterval = time.Second
+	// jobStaleAfter is how long a running job may go without a heartbeat before another
+	// runner claims it again, e.g. after the instance running it was restarted
+	jobStaleAfter = time.Minute
+	// jobRetention is how long finished jobs and their results are kept
+	jobRetention = 7 * 24 * time.Hour
+	// jobCleanupInterval is how often the runner deletes the jobs past jobRetention
+	jobCleanupInterval = time.Hour
+)
+
+// JobService submits bulk calculation jobs and runs them in the background, one at a time.
+type JobService struct {
+	store    JobStore
+	settings *SettingsService
+	limits   UploadLimits
+	workers  int
+	// wake signals the runner that a job was submitted
+	wake chan struct{}
+
+	mu sync.Mutex
+	// cancels holds the cancel function of the job running in this instance
+	cancels map[string]context.CancelFunc
+}
+
+// NewJobService creates a JobService storing jobs in store.
+func NewJobService(store JobStore, settings *SettingsService) *JobService {
+	return &JobService{
+		store:    store,
+		settings: settings,
+		limits:   DefaultUploadLimits(),
+		workers:  DefaultWorkers(),
+		wake:     make(chan struct{}, 1),
+		cancels:  map[string]context.CancelFunc{},
+	}
+}
+
+// SetUploadLimits sets the limits of submitted files.
+func (s *JobService) SetUploadLimits(limits UploadLimits) {
+	s.limits = limits
+}
+
+// SetWorkers sets the number of goroutines calculating the rows of a job.
+func (s *JobService) SetWorkers(workers int) {
+	s.workers = workers
+}
+
+// newJobID returns a random job ID
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Submit stores a new queued job calculating the uploaded file read from input.
+func (s *JobService) Submit(ctx context.Context, options UploadOptions, input io.Reader) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+	now := s.settings.Now()
+	job := Job{ID: id, Status: JobQueued, Options: options, CreatedAt: now, UpdatedAt: now}
+	if err := s.store.CreateJob(ctx, job, input); err != nil {
+		return Job{}, err
+	}
+
+	// Wake the runner unless it is already woken
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Job returns the job with the given ID or ErrJobNotFound.
+func (s *JobService) Job(ctx context.Context, id string) (Job, error) {
+	return s.store.GetJob(ctx, id)
+}
+
+// Result returns the result of a succeeded job or ErrJobNotFound. The caller must close it.
+func (s *JobService) Result(ctx context.Context, id string) (io.ReadCloser, error) {
+	return s.store.GetResult(ctx, id)
+}
+
+// Cancel cancels a queued or running job; ok is false when it had already finished.
+func (s *JobService) Cancel(ctx context.Context, id string) (Job, bool, error) {
+	job, ok, err := s.store.CancelJob(ctx, id, s.settings.Now())
+	if err != nil || !ok {
+		return job, ok, err
+	}
+
+	// Stop the job right away when this instance runs it; other instances stop at
+	// their next heartbeat
+	s.mu.Lock()
+	if cancel, running := s.cancels[id]; running {
+		cancel()
+	}
+	s.mu.Unlock()
+	return job, true, nil
+}
+
+// Run runs queued and stale jobs and deletes old finished jobs until ctx is done.
+func (s *JobService) Run(ctx context.Context) {
+	var cleaned time.Time
+	for {
+		if time.Since(cleaned) >= jobCleanupInterval {
+			cleaned = time.Now()
+			if err := s.cleanup(ctx); err != nil {
+				log.Printf("Error deleting finished tax jobs: %v", err)
+			}
+		}
+
+		ran, err := s.runNext(ctx)
+		if err != nil {
+			log.Printf("Error running tax job: %v", err)
+		}
+		if ran && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// cleanup deletes the jobs that finished more than jobRetention ago
+func (s *JobService) cleanup(ctx context.Context) error {
+	_, err := s.store.DeleteJobs(ctx, s.settings.Now().Add(-jobRetention))
+	return err
+}
+
+// runNext claims and runs the next job, reporting whether there was one
+func (s *JobService) runNext(ctx context.Context) (bool, error) {
+	now := s.settings.Now()
+	job, ok, err := s.store.ClaimJob(ctx, now, now.Add(-jobStaleAfter))
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, s.run(ctx, job)
+}
+
+// spool copies r to a new temporary file, which the caller must close and remove
+func spool(r io.Reader, pattern string) (*os.File, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		removeTemp(f)
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		removeTemp(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// removeTemp closes and removes a temporary file
+func removeTemp(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// run calculates a claimed job through temporary files and records its final state
+func (s *JobService) run(ctx context.Context, job Job) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	s.cancels[job.ID] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.cancels, job.ID)
+		s.mu.Unlock()
+	}()
+
+	// Send heartbeats for the whole attempt, so copying the file or a slow row does not
+	// make the job look stale
+	progress := &jobProgress{ctx: ctx}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.heartbeat(ctx, cancel, job, progress, stop)
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	// Copy the uploaded file, which is gone when the job was cancelled meanwhile
+	src, err := s.store.OpenInput(ctx, job.ID)
+	if errors.Is(err, ErrJobNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	input, err := spool(src, "tax-job-input-*")
+	src.Close()
+	if err != nil {
+		return err
+	}
+	defer removeTemp(input)
+	result, err := os.CreateTemp("", "tax-job-result-*")
+	if err != nil {
+		return err
+	}
+	defer removeTemp(result)
+
+	// Calculate with the settings in effect when the job was submitted
+	err = s.calculate(job, input, progress, result)
+	if ctx.Err() != nil {
+		// The job was cancelled, claimed again or the runner is shutting down
+		return nil
+	}
+
+	now := s.settings.Now()
+	job.RowsDone = int(progress.rowsDone.Load())
+	job.RowsFailed = int(progress.rowsFailed.Load())
+	job.FinishedAt = &now
+	job.UpdatedAt = now
+	job.Status = JobSucceeded
+	if err != nil {
+		_, response := uploadError(err)
+		job.Status = JobFailed
+		job.Error = &response
+	}
+	if _, err := result.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = s.store.FinishJob(ctx, job, result)
+	return err
+}
+
+// calculate calculates the uploaded file of a job in one pass, writing its results to w
+func (s *JobService) calculate(job Job, input io.ReadSeeker, progress *jobProgress, w io.Writer) error {
+	rules, err := ruleSet(job.Options.TaxYear, s.settings.Snapshot(), job.CreatedAt)
+	if err != nil {
+		return parameterError(err, "taxYear", fmt.Sprintf("Invalid value for taxYear: %v", err))
+	}
+	calculation := bulkCalculation{
+		options: job.Options,
+		rules:   rules,
+		maxRows: s.limits.MaxRows,
+		workers: s.workers,
+		open: func() (recordReader, error) {
+			return newRecordReader(input, io.NopCloser(nil), job.Options.Filename, job.Options.Sheet, job.Options.Encoding)
+		},
+	}
+	return calculation.write(w, progress.count)
+}
+
+// jobProgress counts the rows of a running job
+type jobProgress struct {
+	ctx        context.Context
+	rowsDone   atomic.Int64
+	rowsFailed atomic.Int64
+}
+
+// count counts one calculated row, returning an error when the job must stop
+func (p *jobProgress) count(row resultRow) error {
+	p.rowsDone.Add(1)
+	if row.err != nil {
+		p.rowsFailed.Add(1)
+	}
+	return p.ctx.Err()
+}
+
+// heartbeat records the progress of an attempt until stop is closed, cancelling it when it lost the job
+func (s *JobService) heartbeat(ctx context.Context, cancel context.CancelFunc, job Job, progress *jobProgress, stop <-chan struct{}) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ok, err := s.store.UpdateProgress(ctx, job.ID, job.Attempt, int(progress.rowsDone.Load()), int(progress.rowsFailed.Load()), s.settings.Now())
+		if err != nil {
+			// Keep running; the job is claimed again if the heartbeats keep failing
+			log.Printf("Error recording progress of tax job %s: %v", job.ID, err)
+			continue
+		}
+		if !ok {
+			cancel()
+			return
+		}
+	}
+}
+
+// SubmitJobHandler handles POST /tax/jobs and queues an upload as a job.
+func (h *Handler) SubmitJobHandler(c echo.Context) error {
+	// Reject invalid parameters before the job is queued
+	upload, err := h.readUpload(c)
+	if err != nil {
+		return uploadFailed(c, err)
+	}
+
+	// Stream the uploaded file to the job store
+	src, err := upload.file.Open()
+	if err != nil {
+		return uploadFailed(c, err)
+	}
+	defer src.Close()
+
+	request := c.Request()
+	job, err := h.jobs.Submit(request.Context(), upload.options, src)
+	if err != nil {
+		return internalError(c, "Error submitting job")
+	}
+	c.Response().Header().Set(echo.HeaderLocation, path.Join(request.URL.Path, job.ID))
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// JobHandler handles GET /tax/jobs/:id and returns the state and progress of a job.
+func (h *Handler) JobHandler(c echo.Context) error {
+	job, err := h.jobs.Job(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return jobFailed(c, err)
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// JobResultHandler handles GET /tax/jobs/:id/result and returns the results of a succeeded job.
+func (h *Handler) JobResultHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	job, err := h.jobs.Job(ctx, c.Param("id"))
+	if err != nil {
+		return jobFailed(c, err)
+	}
+	if job.Status != JobSucceeded {
+		return errorJSON(c, http.StatusConflict, ErrorCodeConflict, fmt.Sprintf("Job is %s", job.Status))
+	}
+	result, err := h.jobs.Result(ctx, job.ID)
+	if err != nil {
+		return jobFailed(c, err)
+	}
+	defer result.Close()
+
+	contentType, filename := resultMediaType(job.Options.Format)
+	if job.Options.Format != FormatJSON {
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	return c.Stream(http.StatusOK, contentType, result)
+}
+
+// CancelJobHandler handles DELETE /tax/jobs/:id and cancels a queued or running job.
+func (h *Handler) CancelJobHandler(c echo.Context) error {
+	job, ok, err := h.jobs.Cancel(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return jobFailed(c, err)
+	}
+	if !ok {
+		return errorJSON(c, http.StatusConflict, ErrorCodeConflict, fmt.Sprintf("Job is already %s", job.Status))
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// jobFailed writes the ErrorResponse of a job that could not be read
+func jobFailed(c echo.Context, err error) error {
+	if errors.Is(err, ErrJobNotFound) {
+		return errorJSON(c, http.StatusNotFound, ErrorCodeNotFound, "Job not found")
+	}
+	return internalError(c, "Error reading job")
+}
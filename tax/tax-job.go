@@ -0,0 +1,250 @@
+package tax
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobStatus is the state of a bulk calculation job.
+type JobStatus string
+
+// States of a bulk calculation job.
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Finished reports whether the job will not change anymore.
+func (s JobStatus) Finished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCancelled
+}
+
+// ErrJobNotFound is returned for an unknown job ID.
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is a bulk calculation of an uploaded file run in the background.
+type Job struct {
+	ID         string         `json:"id"`
+	Status     JobStatus      `json:"status"`
+	Options    UploadOptions  `json:"options"`
+	RowsDone   int            `json:"rowsDone"`
+	RowsFailed int            `json:"rowsFailed"`
+	Error      *ErrorResponse `json:"error,omitempty"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	StartedAt  *time.Time     `json:"startedAt,omitempty"`
+	FinishedAt *time.Time     `json:"finishedAt,omitempty"`
+	// UpdatedAt is the last heartbeat of the worker running the job
+	UpdatedAt time.Time `json:"-"`
+	// Attempt counts the claims of the job, so a worker whose job was claimed again
+	// after it stopped sending heartbeats cannot overwrite the new attempt
+	Attempt int `json:"-"`
+}
+
+// JobStore persists jobs with their uploaded files and results.
+type JobStore interface {
+	// CreateJob stores a new queued job with its uploaded file read from input.
+	CreateJob(ctx context.Context, job Job, input io.Reader) error
+	// GetJob returns the job with the given ID or ErrJobNotFound.
+	GetJob(ctx context.Context, id string) (Job, error)
+	// ClaimJob marks the oldest queued job, or a running job without a heartbeat since
+	// staleBefore, as running and returns it. ok is false when there is no job to run.
+	ClaimJob(ctx context.Context, now time.Time, staleBefore time.Time) (job Job, ok bool, err error)
+	// OpenInput returns the uploaded file of an unfinished job or ErrJobNotFound.
+	OpenInput(ctx context.Context, id string) (io.ReadCloser, error)
+	// UpdateProgress records the progress of a running attempt. It returns false when
+	// the attempt must stop because the job was cancelled or claimed again.
+	UpdateProgress(ctx context.Context, id string, attempt int, rowsDone int, rowsFailed int, now time.Time) (bool, error)
+	// FinishJob records the final state of a running attempt and, for a succeeded job,
+	// its result read from result. It returns false when the attempt no longer owns the job.
+	FinishJob(ctx context.Context, job Job, result io.Reader) (bool, error)
+	// CancelJob cancels a queued or running job and returns it. ok is false when the
+	// job had already finished.
+	CancelJob(ctx context.Context, id string, now time.Time) (job Job, ok bool, err error)
+	// GetResult returns the result of a succeeded job or ErrJobNotFound.
+	GetResult(ctx context.Context, id string) (io.ReadCloser, error)
+	// DeleteJobs deletes the jobs finished before finishedBefore with their results and
+	// returns how many were deleted.
+	DeleteJobs(ctx context.Context, finishedBefore time.Time) (int, error)
+}
+
+// MemoryJobStore is a JobStore kept in memory.
+type MemoryJobStore struct {
+	mu      sync.Mutex
+	jobs    map[string]Job
+	inputs  map[string][]byte
+	results map[string][]byte
+}
+
+// NewMemoryJobStore creates an empty MemoryJobStore.
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{jobs: map[string]Job{}, inputs: map[string][]byte{}, results: map[string][]byte{}}
+}
+
+// CreateJob stores a new job in memory.
+func (s *MemoryJobStore) CreateJob(ctx context.Context, job Job, input io.Reader) error {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	s.inputs[job.ID] = data
+	return nil
+}
+
+// GetJob returns the job with the given ID.
+func (s *MemoryJobStore) GetJob(ctx context.Context, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// ClaimJob marks the oldest runnable job as running.
+func (s *MemoryJobStore) ClaimJob(ctx context.Context, now time.Time, staleBefore time.Time) (Job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Select the runnable jobs, oldest first
+	var runnable []Job
+	for _, job := range s.jobs {
+		if job.Status == JobQueued || (job.Status == JobRunning && job.UpdatedAt.Before(staleBefore)) {
+			runnable = append(runnable, job)
+		}
+	}
+	if len(runnable) == 0 {
+		return Job{}, false, nil
+	}
+	sort.Slice(runnable, func(i, j int) bool {
+		if !runnable[i].CreatedAt.Equal(runnable[j].CreatedAt) {
+			return runnable[i].CreatedAt.Before(runnable[j].CreatedAt)
+		}
+		return runnable[i].ID < runnable[j].ID
+	})
+
+	job := runnable[0]
+	job.Status = JobRunning
+	job.Attempt++
+	job.RowsDone = 0
+	job.RowsFailed = 0
+	job.StartedAt = &now
+	job.UpdatedAt = now
+	s.jobs[job.ID] = job
+	return job, true, nil
+}
+
+// OpenInput returns the uploaded file of an unfinished job.
+func (s *MemoryJobStore) OpenInput(ctx context.Context, id string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	input, ok := s.inputs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(input)), nil
+}
+
+// UpdateProgress records the progress of a running attempt.
+func (s *MemoryJobStore) UpdateProgress(ctx context.Context, id string, attempt int, rowsDone int, rowsFailed int, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Status != JobRunning || job.Attempt != attempt {
+		return false, nil
+	}
+	job.RowsDone = rowsDone
+	job.RowsFailed = rowsFailed
+	job.UpdatedAt = now
+	s.jobs[id] = job
+	return true, nil
+}
+
+// FinishJob records the final state of a running attempt.
+func (s *MemoryJobStore) FinishJob(ctx context.Context, job Job, result io.Reader) (bool, error) {
+	var data []byte
+	if job.Status == JobSucceeded {
+		var err error
+		if data, err = io.ReadAll(result); err != nil {
+			return false, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.jobs[job.ID]
+	if !ok || stored.Status != JobRunning || stored.Attempt != job.Attempt {
+		return false, nil
+	}
+	s.jobs[job.ID] = job
+	if job.Status == JobSucceeded {
+		s.results[job.ID] = data
+	}
+	// The uploaded file is no longer needed
+	delete(s.inputs, job.ID)
+	return true, nil
+}
+
+// CancelJob cancels a queued or running job.
+func (s *MemoryJobStore) CancelJob(ctx context.Context, id string, now time.Time) (Job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false, ErrJobNotFound
+	}
+	if job.Status.Finished() {
+		return job, false, nil
+	}
+	job.Status = JobCancelled
+	job.FinishedAt = &now
+	job.UpdatedAt = now
+	s.jobs[id] = job
+	delete(s.inputs, id)
+	return job, true, nil
+}
+
+// GetResult returns the result of a succeeded job.
+func (s *MemoryJobStore) GetResult(ctx context.Context, id string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, ok := s.results[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(result)), nil
+}
+
+// DeleteJobs deletes the jobs finished before finishedBefore.
+func (s *MemoryJobStore) DeleteJobs(ctx context.Context, finishedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, job := range s.jobs {
+		if job.Status.Finished() && job.FinishedAt != nil && job.FinishedAt.Before(finishedBefore) {
+			delete(s.jobs, id)
+			delete(s.inputs, id)
+			delete(s.results, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
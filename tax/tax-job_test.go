@@ -0,0 +1,349 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// readJobData reads and closes an input or result opened from a JobStore
+func readJobData(r io.ReadCloser, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+// testJobStore runs the checks every JobStore must pass
+func testJobStore(t *testing.T, store JobStore) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	options := UploadOptions{Filename: "taxes.csv", OnError: CSVOnErrorAbort, Format: FormatJSON}
+
+	newJob := func(createdAt time.Time) Job {
+		id, err := newJobID()
+		assert.NoError(t, err)
+		job := Job{ID: id, Status: JobQueued, Options: options, CreatedAt: createdAt, UpdatedAt: createdAt}
+		assert.NoError(t, store.CreateJob(ctx, job, strings.NewReader("totalIncome\n500000\n")))
+		return job
+	}
+	older := newJob(now.Add(-time.Hour))
+	newer := newJob(now)
+
+	// Jobs are claimed oldest first
+	claimed, ok, err := store.ClaimJob(ctx, now, now.Add(-jobStaleAfter))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, older.ID, claimed.ID)
+	assert.Equal(t, JobRunning, claimed.Status)
+	assert.Equal(t, 1, claimed.Attempt)
+	assert.Equal(t, options, claimed.Options)
+	input, err := readJobData(store.OpenInput(ctx, claimed.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, "totalIncome\n500000\n", input)
+
+	ok, err = store.UpdateProgress(ctx, claimed.ID, claimed.Attempt, 10, 1, now)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = store.UpdateProgress(ctx, claimed.ID, claimed.Attempt+1, 10, 1, now)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// A running job without a heartbeat is claimed again and the old attempt can no longer finish it
+	later := now.Add(2 * jobStaleAfter)
+	reclaimed, ok, err := store.ClaimJob(ctx, later, later.Add(-jobStaleAfter))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, older.ID, reclaimed.ID)
+	assert.Equal(t, 2, reclaimed.Attempt)
+	assert.Equal(t, 0, reclaimed.RowsDone)
+
+	claimed.Status = JobSucceeded
+	ok, err = store.FinishJob(ctx, claimed, strings.NewReader("stale"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	reclaimed.Status = JobSucceeded
+	reclaimed.RowsDone = 1
+	reclaimed.FinishedAt = &later
+	ok, err = store.FinishJob(ctx, reclaimed, strings.NewReader(`{"taxes":[]}`))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	job, err := store.GetJob(ctx, older.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, JobSucceeded, job.Status)
+	assert.Equal(t, 1, job.RowsDone)
+	result, err := readJobData(store.GetResult(ctx, older.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"taxes":[]}`, result)
+	_, err = store.OpenInput(ctx, older.ID)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	// Only unfinished jobs can be cancelled
+	_, ok, err = store.CancelJob(ctx, older.ID, later)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	job, ok, err = store.CancelJob(ctx, newer.ID, later)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, JobCancelled, job.Status)
+	_, err = store.GetResult(ctx, newer.ID)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	// Finished jobs are deleted with their results once they are past the cutoff
+	deleted, err := store.DeleteJobs(ctx, later)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+	deleted, err = store.DeleteJobs(ctx, later.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	_, err = store.GetJob(ctx, older.ID)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+	_, err = store.GetResult(ctx, older.ID)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	_, err = store.GetJob(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrJobNotFound)
+	_, _, err = store.CancelJob(ctx, "unknown", later)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestMemoryJobStore(t *testing.T) {
+	testJobStore(t, NewMemoryJobStore())
+}
+
+func TestPostgresJobStore(t *testing.T) {
+	db := openTestDatabase(t)
+	// Start from an empty table so the oldest job is one of this test
+	if _, err := db.Exec(`DELETE FROM tax_jobs`); err != nil {
+		t.Fatal(err)
+	}
+	testJobStore(t, NewPostgresJobStore(db))
+}
+
+// newTestJobHandler returns a handler with an in-memory job service that is not running
+func newTestJobHandler(t *testing.T) (*Handler, *JobService) {
+	t.Helper()
+
+	h := newTestHandler(t)
+	jobs := NewJobService(NewMemoryJobStore(), h.settings)
+	h.SetJobs(jobs)
+	return h, jobs
+}
+
+// serveJob calls a job handler with the id path parameter
+func serveJob(t *testing.T, handler echo.HandlerFunc, method string, id string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(method, "/tax/jobs/"+id, nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	assert.NoError(t, handler(c))
+	return rec
+}
+
+// submitJob submits a file and returns the queued job
+func submitJob(t *testing.T, h *Handler, content string, fields map[string]string) Job {
+	t.Helper()
+
+	e := echo.New()
+	req := newUploadRequest(t, "/tax/jobs", "taxes.csv", []byte(content), fields)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, h.SubmitJobHandler(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	var job Job
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(t, JobQueued, job.Status)
+	assert.Equal(t, "/tax/jobs/"+job.ID, rec.Header().Get(echo.HeaderLocation))
+	return job
+}
+
+func TestJobHandlers(t *testing.T) {
+	h, jobs := newTestJobHandler(t)
+	ctx := context.Background()
+
+	job := submitJob(t, h, "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n", nil)
+
+	// The result is not available before the job has run
+	rec := serveJob(t, h.JobResultHandler, http.MethodGet, job.ID)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"conflict"`)
+
+	ran, err := jobs.runNext(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ran)
+
+	rec = serveJob(t, h.JobHandler, http.MethodGet, job.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(t, JobSucceeded, job.Status)
+	assert.Equal(t, 3, job.RowsDone)
+	assert.Equal(t, 0, job.RowsFailed)
+	assert.NotNil(t, job.FinishedAt)
+
+	rec = serveJob(t, h.JobResultHandler, http.MethodGet, job.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"taxes":[{"totalIncome":500000,"tax":29000},{"totalIncome":600000,"tax":0},{"totalIncome":750000,"tax":11250}]}`, rec.Body.String())
+
+	// Finished jobs cannot be cancelled
+	rec = serveJob(t, h.CancelJobHandler, http.MethodDelete, job.ID)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = serveJob(t, h.JobHandler, http.MethodGet, "unknown")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"not_found"`)
+}
+
+func TestJobHandlersFailedJob(t *testing.T) {
+	h, jobs := newTestJobHandler(t)
+
+	job := submitJob(t, h, "totalIncome\n500000\ninvalid\n", nil)
+	ran, err := jobs.runNext(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ran)
+
+	rec := serveJob(t, h.JobHandler, http.MethodGet, job.ID)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(t, JobFailed, job.Status)
+	if assert.NotNil(t, job.Error) {
+		assert.Equal(t, ErrorCodeInvalidFile, job.Error.Code)
+		assert.True(t, strings.HasPrefix(job.Error.Message, "Error calculating tax: row 3"))
+	}
+
+	rec = serveJob(t, h.JobResultHandler, http.MethodGet, job.ID)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestJobHandlersContinueAndFormat(t *testing.T) {
+	h, jobs := newTestJobHandler(t)
+
+	job := submitJob(t, h, "totalIncome\n500000\ninvalid\n", map[string]string{"onError": "continue", "format": "csv"})
+	_, err := jobs.runNext(context.Background())
+	assert.NoError(t, err)
+
+	rec := serveJob(t, h.JobHandler, http.MethodGet, job.ID)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(t, JobSucceeded, job.Status)
+	assert.Equal(t, 2, job.RowsDone)
+	assert.Equal(t, 1, job.RowsFailed)
+
+	rec = serveJob(t, h.JobResultHandler, http.MethodGet, job.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, MIMETextCSV+"; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentDisposition), "taxes.csv")
+	assert.Contains(t, rec.Body.String(), "500000,29000,0,0,29000")
+}
+
+func TestJobHandlersCancel(t *testing.T) {
+	h, jobs := newTestJobHandler(t)
+
+	job := submitJob(t, h, "totalIncome\n500000\n", nil)
+	rec := serveJob(t, h.CancelJobHandler, http.MethodDelete, job.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(t, JobCancelled, job.Status)
+
+	// Cancelled jobs are not run
+	ran, err := jobs.runNext(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ran)
+}
+
+func TestSubmitJobHandlerInvalidParameters(t *testing.T) {
+	h, _ := newTestJobHandler(t)
+
+	tests := []struct {
+		name      string
+		fields    map[string]string
+		parameter string
+	}{
+		{name: "onError", fields: map[string]string{"onError": "skip"}, parameter: "onError"},
+		{name: "format", fields: map[string]string{"format": "pdf"}, parameter: "format"},
+		{name: "taxYear", fields: map[string]string{"taxYear": "1999"}, parameter: "taxYear"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := newUploadRequest(t, "/tax/jobs", "taxes.csv", []byte("totalIncome\n500000\n"), tt.fields)
+			rec := httptest.NewRecorder()
+			assert.NoError(t, h.SubmitJobHandler(e.NewContext(req, rec)))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"parameter":"`+tt.parameter+`"`)
+		})
+	}
+}
+
+func TestJobServiceRun(t *testing.T) {
+	h, jobs := newTestJobHandler(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go jobs.Run(ctx)
+
+	// Submitting wakes the runner
+	job := submitJob(t, h, "totalIncome\n500000\n", nil)
+	assert.Eventually(t, func() bool {
+		job, err := jobs.Job(ctx, job.ID)
+		return err == nil && job.Status == JobSucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// blockingInputStore is a JobStore whose uploaded files block until the job stops
+type blockingInputStore struct {
+	*MemoryJobStore
+}
+
+// blockingReader blocks until its context is done
+type blockingReader struct {
+	ctx context.Context
+}
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	<-r.ctx.Done()
+	return 0, r.ctx.Err()
+}
+
+func (s blockingInputStore) OpenInput(ctx context.Context, id string) (io.ReadCloser, error) {
+	return io.NopCloser(blockingReader{ctx: ctx}), nil
+}
+
+func TestJobServiceHeartbeat(t *testing.T) {
+	h := newTestHandler(t)
+	store := blockingInputStore{NewMemoryJobStore()}
+	jobs := NewJobService(store, h.settings)
+	h.SetJobs(jobs)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go jobs.Run(ctx)
+
+	// Heartbeats are sent while the uploaded file is still being copied
+	job := submitJob(t, h, "totalIncome\n500000\n", nil)
+	assert.Eventually(t, func() bool {
+		job, err := jobs.Job(ctx, job.ID)
+		return err == nil && job.Status == JobRunning && job.UpdatedAt.After(*job.StartedAt)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A job cancelled by another instance stops at the next heartbeat
+	_, ok, err := store.CancelJob(ctx, job.ID, time.Now())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Eventually(t, func() bool {
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		return len(jobs.cancels) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
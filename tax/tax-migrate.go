@@ -0,0 +1,123 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrationLockID is the advisory lock key that serializes concurrent migrations.
+const migrationLockID = 72542567
+
+// migrations are the schema changes of the database, applied in order.
+// Append new migrations to the end; never edit a migration that has been released.
+var migrations = []string{
+	// 1: admin deduction settings
+	`CREATE TABLE deduction_settings (
+		name          TEXT PRIMARY KEY,
+		amount_satang BIGINT NOT NULL,
+		updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	// 2: append-only audit trail of admin deduction changes
+	`CREATE TABLE deduction_audit (
+		id               BIGSERIAL PRIMARY KEY,
+		setting          TEXT NOT NULL,
+		old_value_satang BIGINT NOT NULL,
+		new_value_satang BIGINT NOT NULL,
+		username         TEXT NOT NULL,
+		request_id       TEXT NOT NULL,
+		changed_at       TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX deduction_audit_setting_changed_at ON deduction_audit (setting, changed_at);
+	CREATE FUNCTION deduction_audit_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'deduction_audit is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+	CREATE TRIGGER deduction_audit_append_only
+		BEFORE UPDATE OR DELETE ON deduction_audit
+		FOR EACH ROW EXECUTE FUNCTION deduction_audit_append_only()`,
+	// 3: effective-dated history of admin deduction settings
+	`CREATE TABLE deduction_setting_history (
+		id             BIGSERIAL PRIMARY KEY,
+		name           TEXT NOT NULL,
+		amount_satang  BIGINT NOT NULL,
+		effective_from TIMESTAMPTZ NOT NULL,
+		created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX deduction_setting_history_effective_from ON deduction_setting_history (effective_from, id);
+	INSERT INTO deduction_setting_history (name, amount_satang, effective_from)
+		SELECT name, amount_satang, updated_at FROM deduction_settings;
+	DROP TABLE deduction_settings;
+	ALTER TABLE deduction_audit ADD COLUMN effective_from TIMESTAMPTZ`,
+	// 4: asynchronous bulk calculation jobs with their uploaded files and results
+	`CREATE TABLE tax_jobs (
+		id          TEXT PRIMARY KEY,
+		status      TEXT NOT NULL,
+		options     JSONB NOT NULL,
+		rows_done   INTEGER NOT NULL DEFAULT 0,
+		rows_failed INTEGER NOT NULL DEFAULT 0,
+		error       JSONB,
+		attempt     INTEGER NOT NULL DEFAULT 0,
+		input       BYTEA,
+		result      BYTEA,
+		created_at  TIMESTAMPTZ NOT NULL,
+		started_at  TIMESTAMPTZ,
+		finished_at TIMESTAMPTZ,
+		updated_at  TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX tax_jobs_status_created_at ON tax_jobs (status, created_at, id)`,
+	// 5: uploaded files and results of jobs stored in chunks, so they are streamed
+	`CREATE TABLE tax_job_chunks (
+		job_id TEXT NOT NULL REFERENCES tax_jobs (id) ON DELETE CASCADE,
+		kind   TEXT NOT NULL,
+		seq    INTEGER NOT NULL,
+		data   BYTEA NOT NULL,
+		PRIMARY KEY (job_id, kind, seq)
+	);
+	INSERT INTO tax_job_chunks (job_id, kind, seq, data)
+		SELECT id, 'input', 0, input FROM tax_jobs WHERE input IS NOT NULL;
+	INSERT INTO tax_job_chunks (job_id, kind, seq, data)
+		SELECT id, 'result', 0, result FROM tax_jobs WHERE result IS NOT NULL;
+	ALTER TABLE tax_jobs DROP COLUMN input, DROP COLUMN result`,
+	// 6: finished jobs are deleted after their retention period
+	`CREATE INDEX tax_jobs_finished_at ON tax_jobs (finished_at) WHERE finished_at IS NOT NULL`,
+}
+
+// Migrate applies the migrations that have not been applied to the database yet.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize migrations when several instances start at the same time
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+
+	return tx.Commit()
+}
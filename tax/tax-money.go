@@ -0,0 +1,274 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money represents an amount of Thai baht as a whole number of satang.
+//
+// Amounts are marshalled to and from JSON as plain baht numbers, e.g. 29000.5,
+// so the wire format is the same as a float64 baht value.
+type Money int64
+
+// Money units.
+const (
+	Satang Money = 1
+	Baht   Money = 100
+)
+
+// Rate represents a tax or deduction rate in basis points (1/10,000).
+//
+// Rates are marshalled to and from JSON as fractions, e.g. 0.1 for 10%.
+type Rate int64
+
+// Rate units.
+const (
+	BasisPoint Rate = 1
+	Percent    Rate = 100
+)
+
+// MaxMoney is the largest amount accepted as input. Any amount up to MaxMoney can be
+// multiplied by a rate of up to 200% and summed thousands of times without overflowing.
+const MaxMoney = 1000000000000 * Baht
+
+// ParseMoney parses a decimal baht amount such as "1250000" or "0.125".
+// Amounts with more than two decimals are rounded half away from zero to the satang.
+// Amounts beyond MaxMoney either way are rejected.
+func ParseMoney(s string) (Money, error) {
+	v, err := parseFixed(s, 2)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if v > int64(MaxMoney) || v < -int64(MaxMoney) {
+		return 0, fmt.Errorf("invalid amount %q: must not exceed %s", s, MaxMoney)
+	}
+	return Money(v), nil
+}
+
+// ParseRate parses a decimal fraction such as "0.1" into a rate.
+// Rates with more than four decimals are rounded half away from zero to the basis point.
+func ParseRate(s string) (Rate, error) {
+	v, err := parseFixed(s, 4)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return Rate(v), nil
+}
+
+// Float64 returns the amount in baht as a float64.
+func (m Money) Float64() float64 {
+	return float64(m) / float64(Baht)
+}
+
+// String returns the amount in baht without thousands separators, e.g. "29000.50".
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/Baht, m%Baht)
+}
+
+// MulRate multiplies the amount by a rate, rounding half away from zero to the satang.
+func (m Money) MulRate(r Rate) Money {
+	return Money(divRound(int64(m)*int64(r), 10000))
+}
+
+// Min returns the smaller of the amount and other.
+func (m Money) Min(other Money) Money {
+	if other < m {
+		return other
+	}
+	return m
+}
+
+// MarshalJSON encodes the amount as a baht number.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(formatFixed(int64(m), 2)), nil
+}
+
+// AmountError is returned when a JSON value cannot be decoded into a Money or a Rate.
+// Pointer is the JSON pointer of the value when it is known; decoding a request with
+// bind sets it.
+type AmountError struct {
+	Pointer string
+	Err     error
+}
+
+// Error returns the reason the value was rejected, prefixed with its pointer if known.
+func (e *AmountError) Error() string {
+	if e.Pointer != "" {
+		return e.Pointer + ": " + e.Err.Error()
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the reason the value was rejected.
+func (e *AmountError) Unwrap() error {
+	return e.Err
+}
+
+// amountError returns the *AmountError of a JSON value rejected by parse
+func amountError(data []byte, err error) error {
+	if data[0] != '-' && (data[0] < '0' || data[0] > '9') {
+		err = fmt.Errorf("invalid value %s: must be a number", data)
+	}
+	return &AmountError{Err: err}
+}
+
+// UnmarshalJSON decodes a baht number into the amount. Invalid amounts are rejected
+// with an *AmountError.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	v, err := ParseMoney(string(data))
+	if err != nil {
+		return amountError(data, err)
+	}
+	*m = v
+	return nil
+}
+
+// String returns the rate as a percentage, e.g. "10%".
+func (r Rate) String() string {
+	return formatFixed(int64(r), 2) + "%"
+}
+
+// MarshalJSON encodes the rate as a fraction.
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(formatFixed(int64(r), 4)), nil
+}
+
+// UnmarshalJSON decodes a fraction into the rate. Invalid rates are rejected with an
+// *AmountError.
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	v, err := ParseRate(string(data))
+	if err != nil {
+		return amountError(data, err)
+	}
+	*r = v
+	return nil
+}
+
+// decimalSyntax matches the decimal numbers parseFixed accepts, with an optional exponent.
+// big.Rat also accepts fractions such as "12/05", base prefixes such as "0x10" and digit
+// separators such as "1_000", which must not be read as amounts.
+var decimalSyntax = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d{1,4})?$`)
+
+// parseFixed parses a decimal number into an integer scaled by 10^scale,
+// rounding half away from zero.
+func parseFixed(s string, scale int) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty number")
+	}
+
+	// Plain decimals are parsed without big.Rat, which dominates bulk calculations
+	if v, ok := parsePlainFixed(s, scale); ok {
+		return v, nil
+	}
+
+	if !decimalSyntax.MatchString(s) {
+		return 0, errors.New("not a decimal number")
+	}
+	rat, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, errors.New("not a number")
+	}
+
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	scaled := new(big.Rat).Mul(rat, new(big.Rat).SetInt(unit))
+
+	// Round half away from zero
+	num := new(big.Int).Abs(scaled.Num())
+	quo, rem := new(big.Int).QuoRem(num, scaled.Denom(), new(big.Int))
+	if new(big.Int).Mul(rem, big.NewInt(2)).Cmp(scaled.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+	if scaled.Sign() < 0 {
+		quo.Neg(quo)
+	}
+	if !quo.IsInt64() {
+		return 0, errors.New("number out of range")
+	}
+	return quo.Int64(), nil
+}
+
+// parsePlainFixed parses a plain decimal number such as "-1250000.125" into an integer
+// scaled by 10^scale, rounding half away from zero. It reports false for any other form,
+// such as exponents, and for numbers too long to be sure they fit in an int64.
+func parsePlainFixed(s string, scale int) (int64, bool) {
+	negative := false
+	if s[0] == '-' || s[0] == '+' {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+	whole, frac, hasDot := strings.Cut(s, ".")
+	if (whole == "" && frac == "") || (hasDot && frac == "") || len(whole)+scale > 18 {
+		return 0, false
+	}
+
+	var v int64
+	for i := 0; i < len(whole); i++ {
+		if whole[i] < '0' || whole[i] > '9' {
+			return 0, false
+		}
+		v = v*10 + int64(whole[i]-'0')
+	}
+	for i := 0; i < len(frac); i++ {
+		if frac[i] < '0' || frac[i] > '9' {
+			return 0, false
+		}
+	}
+	for i := 0; i < scale; i++ {
+		v *= 10
+		if i < len(frac) {
+			v += int64(frac[i] - '0')
+		}
+	}
+
+	// Round half away from zero on the first dropped digit
+	if len(frac) > scale && frac[scale] >= '5' {
+		v++
+	}
+	if negative {
+		v = -v
+	}
+	return v, true
+}
+
+// formatFixed formats an integer scaled by 10^scale as a decimal number without trailing zeros.
+func formatFixed(v int64, scale int) string {
+	s := strconv.FormatInt(v, 10)
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		s = s[1:]
+	}
+	if len(s) <= scale {
+		s = strings.Repeat("0", scale-len(s)+1) + s
+	}
+	whole, frac := s[:len(s)-scale], strings.TrimRight(s[len(s)-scale:], "0")
+	if frac == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + frac
+}
+
+// divRound divides a by b, rounding half away from zero. b must be positive.
+func divRound(a, b int64) int64 {
+	if a < 0 {
+		return -divRound(-a, b)
+	}
+	return (a + b/2) / b
+}
@@ -0,0 +1,103 @@
+package tax
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Money
+		wantErr  bool
+	}{
+		{"500000", 500000 * Baht, false},
+		{"0.5", 50 * Satang, false},
+		{"28999.999999", 29000 * Baht, false},
+		{"0.125", 13 * Satang, false},
+		{"-0.125", -13 * Satang, false},
+		{"1e6", 1000000 * Baht, false},
+		{"+.5", 50 * Satang, false},
+		{"1.", 1 * Baht, false},
+		{"0.0049", 0, false},
+		{"-0.005", -1 * Satang, false},
+		{"1000000000000", MaxMoney, false},
+		{"-1000000000000", -MaxMoney, false},
+		{"1000000000000.01", 0, true},
+		{"-1000000000000.01", 0, true},
+		{"1e14", 0, true},
+		{"99999999999999999.99", 0, true},
+		{"abc", 0, true},
+		{"1.2.3", 0, true},
+		{"-", 0, true},
+		{"", 0, true},
+		{"12/05", 0, true},
+		{"0x10", 0, true},
+		{"0b101", 0, true},
+		{"1_000", 0, true},
+		{"Inf", 0, true},
+		{"1E+2", 100 * Baht, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			actual, err := ParseMoney(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestParseFixedPlainMatchesRat(t *testing.T) {
+	inputs := []string{"0", "-0", "7", "150000", "29000.5", "0.125", "-0.125", "12.3456789", "999999999999.995", ".5", "-.45"}
+	for _, input := range inputs {
+		for _, scale := range []int{2, 4} {
+			plain, ok := parsePlainFixed(input, scale)
+			assert.True(t, ok, input)
+
+			// Parse through big.Rat by making the input not plain
+			rat, err := parseFixed(input+"e0", scale)
+			assert.NoError(t, err, input)
+			assert.Equal(t, rat, plain, "%s at scale %d", input, scale)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Whole    Money `json:"whole"`
+		Fraction Money `json:"fraction"`
+		Negative Money `json:"negative"`
+	}{29000 * Baht, 29000*Baht + 50*Satang, -5 * Satang})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"whole":29000,"fraction":29000.5,"negative":-0.05}`, string(data))
+
+	var request CalculationRequest
+	err = json.Unmarshal([]byte(`{"totalIncome":500000.15,"wht":0.1}`), &request)
+	assert.NoError(t, err)
+	assert.Equal(t, 500000*Baht+15*Satang, request.TotalIncome)
+	assert.Equal(t, 10*Satang, request.WHT)
+}
+
+func TestMoneyMulRate(t *testing.T) {
+	assert.Equal(t, 29000*Baht, (290000 * Baht).MulRate(10*Percent))
+	// 0.15 baht * 10% = 0.015 baht, rounded half away from zero to 0.02
+	assert.Equal(t, 2*Satang, (15 * Satang).MulRate(10*Percent))
+	assert.Equal(t, -2*Satang, (-15 * Satang).MulRate(10*Percent))
+}
+
+func TestCalculateTaxWithSatang(t *testing.T) {
+	// 500,000.15 - 60,000 = 440,000.15; (440,000.15 - 150,000) * 10% = 29,000.015 -> 29,000.02
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	rules.PersonalDeduction = 60000 * Baht
+	response, err := rules.Calculate(CalculationRequest{TotalIncome: 500000*Baht + 15*Satang})
+	assert.NoError(t, err)
+	assert.Equal(t, 29000*Baht+2*Satang, response.Tax)
+}
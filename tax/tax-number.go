@@ -0,0 +1,154 @@
+package tax
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Decimal separators of amounts in uploaded files
+const (
+	DecimalSeparatorAuto = ""  // Detect the decimal separator of every amount
+	DecimalPoint         = "." // 1,250,000.50
+	DecimalComma         = "," // 1.250.000,50
+)
+
+// ParseAmount parses an amount as written in a spreadsheet, such as "1,250,000",
+// "฿ 1,250,000.50", "1.250.000,50 THB" or "(5,000)". Surrounding whitespace, a ฿ or THB
+// currency symbol before or after the number, thousands separators and parentheses or a
+// minus sign for negative amounts are accepted. decimalSeparator is DecimalPoint or
+// DecimalComma; the other one, spaces and non-breaking spaces separate thousands, which
+// must be grouped by three. With DecimalSeparatorAuto the separator is detected from the
+// amount: the last of "." and "," when both are used, "," when it is used once and not
+// followed by exactly three digits, and "." otherwise.
+func ParseAmount(s string, decimalSeparator string) (Money, error) {
+	// Plain amounts, including exponents such as "1.25E+06", are parsed directly
+	if decimalSeparator != DecimalComma {
+		if amount, err := ParseMoney(s); err == nil {
+			return amount, nil
+		}
+	}
+
+	number, negative, ok := stripAmount(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if decimalSeparator == DecimalSeparatorAuto {
+		decimalSeparator = detectDecimalSeparator(number)
+	}
+	plain, ok := ungroupAmount(number, decimalSeparator[0])
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	amount, err := ParseMoney(plain)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// stripAmount removes the whitespace, the currency symbol and the sign around the number
+// of an amount, reporting whether the amount is negative
+func stripAmount(s string) (string, bool, bool) {
+	s = trimCurrency(s)
+	negative := false
+	if len(s) >= 2 && s[0] == '(' && s[len(s)-1] == ')' {
+		negative = true
+		s = trimCurrency(s[1 : len(s)-1])
+	}
+	if strings.HasPrefix(s, "-") {
+		if negative {
+			return "", false, false
+		}
+		negative = true
+		s = trimCurrency(s[1:])
+	}
+	return s, negative, s != ""
+}
+
+// trimCurrency removes the whitespace and a ฿ or THB currency symbol around an amount
+func trimCurrency(s string) string {
+	s = strings.TrimSpace(s)
+	for _, symbol := range []string{"฿", "THB"} {
+		switch {
+		case len(s) >= len(symbol) && strings.EqualFold(s[:len(symbol)], symbol):
+			return strings.TrimSpace(s[len(symbol):])
+		case len(s) >= len(symbol) && strings.EqualFold(s[len(s)-len(symbol):], symbol):
+			return strings.TrimSpace(s[:len(s)-len(symbol)])
+		}
+	}
+	return s
+}
+
+// detectDecimalSeparator returns the decimal separator an unsigned number most likely uses
+func detectDecimalSeparator(number string) string {
+	lastPoint := strings.LastIndexByte(number, '.')
+	lastComma := strings.LastIndexByte(number, ',')
+	switch {
+	case lastPoint >= 0 && lastComma >= 0:
+		if lastComma > lastPoint {
+			return DecimalComma
+		}
+	case lastComma >= 0:
+		// A single comma followed by three digits groups thousands, e.g. "1,250"
+		if strings.Count(number, ",") == 1 && len(number)-lastComma-1 != 3 {
+			return DecimalComma
+		}
+	case lastPoint >= 0:
+		// Several points group thousands, e.g. "1.250.000"
+		if strings.Count(number, ".") > 1 {
+			return DecimalComma
+		}
+	}
+	return DecimalPoint
+}
+
+// ungroupAmount returns an unsigned number without its thousands separators and with a
+// decimal point, reporting false when the digits are not grouped by three
+func ungroupAmount(number string, decimal byte) (string, bool) {
+	integer, fraction := number, ""
+	hasFraction := false
+	if i := strings.IndexByte(number, decimal); i >= 0 {
+		integer, fraction, hasFraction = number[:i], number[i+1:], true
+	}
+	for _, r := range fraction {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+
+	// Split the integer part at its thousands separators
+	var groups []string
+	start := 0
+	for i, r := range integer {
+		switch {
+		case r >= '0' && r <= '9':
+		case (r == '.' || r == ',') && byte(r) != decimal, unicode.IsSpace(r):
+			groups = append(groups, integer[start:i])
+			start = i + len(string(r))
+		default:
+			return "", false
+		}
+	}
+	groups = append(groups, integer[start:])
+	if len(groups) > 1 {
+		if len(groups[0]) == 0 || len(groups[0]) > 3 {
+			return "", false
+		}
+		for _, group := range groups[1:] {
+			if len(group) != 3 {
+				return "", false
+			}
+		}
+	}
+
+	plain := strings.Join(groups, "")
+	if hasFraction {
+		plain += "." + fraction
+	}
+	return plain, true
+}
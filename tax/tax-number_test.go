@@ -0,0 +1,65 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAmount(t *testing.T) {
+	testCases := []struct {
+		input    string
+		decimal  string
+		expected Money
+		wantErr  bool
+	}{
+		{"500000", DecimalSeparatorAuto, 500000 * Baht, false},
+		{" 500000.50 ", DecimalSeparatorAuto, 500000*Baht + 50*Satang, false},
+		{"1,250,000", DecimalSeparatorAuto, 1250000 * Baht, false},
+		{"1,250,000.75", DecimalSeparatorAuto, 1250000*Baht + 75*Satang, false},
+		{"1.250.000,75", DecimalSeparatorAuto, 1250000*Baht + 75*Satang, false},
+		{"1.250.000", DecimalSeparatorAuto, 1250000 * Baht, false},
+		{"1,250", DecimalSeparatorAuto, 1250 * Baht, false},
+		{"500000,5", DecimalSeparatorAuto, 500000*Baht + 50*Satang, false},
+		{"1 250 000", DecimalSeparatorAuto, 1250000 * Baht, false},
+		{"1 250 000,50", DecimalSeparatorAuto, 1250000*Baht + 50*Satang, false},
+		{"฿1,250,000", DecimalSeparatorAuto, 1250000 * Baht, false},
+		{"฿ 1,250,000.00", DecimalSeparatorAuto, 1250000 * Baht, false},
+		{"1,250,000 THB", DecimalSeparatorAuto, 1250000 * Baht, false},
+		{"thb 500", DecimalSeparatorAuto, 500 * Baht, false},
+		{"(5,000)", DecimalSeparatorAuto, -5000 * Baht, false},
+		{"(฿5,000.50)", DecimalSeparatorAuto, -5000*Baht - 50*Satang, false},
+		{"฿(5,000)", DecimalSeparatorAuto, -5000 * Baht, false},
+		{"-฿5,000", DecimalSeparatorAuto, -5000 * Baht, false},
+		{"1.25E+06", DecimalSeparatorAuto, 1250000 * Baht, false},
+		{"1,250", DecimalPoint, 1250 * Baht, false},
+		{"1,250", DecimalComma, 1*Baht + 25*Satang, false},
+		{"1.250", DecimalComma, 1250 * Baht, false},
+		{"1.250,5", DecimalComma, 1250*Baht + 50*Satang, false},
+		{"1,25,000", DecimalSeparatorAuto, 0, true},
+		{"1,2500", DecimalPoint, 0, true},
+		{",250", DecimalPoint, 0, true},
+		{"1.250.000", DecimalPoint, 0, true},
+		{"(-5)", DecimalSeparatorAuto, 0, true},
+		{"(5", DecimalSeparatorAuto, 0, true},
+		{"฿", DecimalSeparatorAuto, 0, true},
+		{"$500", DecimalSeparatorAuto, 0, true},
+		{"abc", DecimalSeparatorAuto, 0, true},
+		{"12/05", DecimalSeparatorAuto, 0, true},
+		{"0x10", DecimalSeparatorAuto, 0, true},
+		{"1_000", DecimalSeparatorAuto, 0, true},
+		{"", DecimalSeparatorAuto, 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input+" "+tc.decimal, func(t *testing.T) {
+			actual, err := ParseAmount(tc.input, tc.decimal)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
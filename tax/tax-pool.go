@@ -0,0 +1,134 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// errStopRows is returned by the callback of csvRows.Each to stop early without an error
+var errStopRows = errors.New("stop reading rows")
+
+// DefaultWorkers returns the number of goroutines calculating the rows of a bulk upload
+// when none is configured: one per CPU.
+func DefaultWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// WorkersFromEnv returns the number of goroutines calculating the rows of a bulk upload
+// configured by the TAX_WORKERS environment variable, or DefaultWorkers when it is unset.
+func WorkersFromEnv() (int, error) {
+	value := os.Getenv("TAX_WORKERS")
+	if value == "" {
+		return DefaultWorkers(), nil
+	}
+	workers, err := strconv.Atoi(value)
+	if err != nil || workers <= 0 {
+		return 0, fmt.Errorf("invalid TAX_WORKERS %q", value)
+	}
+	return workers, nil
+}
+
+// Each calculates the remaining data rows with a pool of workers goroutines and calls fn
+// with every row in file order. Records are read by one goroutine, calculated by the pool
+// and handed to fn as soon as every earlier row is done, so at most a few rows per worker
+// are held in memory. Each stops at the first error of the reader or of fn; errStopRows
+// stops it without an error.
+func (r *csvRows) Each(workers int, fn func(resultRow) error) error {
+	if workers <= 1 {
+		return r.each(fn)
+	}
+
+	// A job is one record and the slot its result is delivered to
+	type job struct {
+		row    int
+		record []string
+		slot   chan resultRow
+	}
+	jobs := make(chan job, workers)
+	slots := make(chan chan resultRow, 4*workers)
+	done := make(chan struct{})
+
+	// Calculate the records in any order
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.slot <- r.calculate(j.row, j.record)
+			}
+		}()
+	}
+
+	// Read the records in file order, queueing the slot of every record before its job
+	var readErr error
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		defer close(slots)
+		defer close(jobs)
+		for {
+			row, record, err := r.read()
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+			slot := make(chan resultRow, 1)
+			select {
+			case slots <- slot:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- job{row: row, record: record, slot: slot}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Hand the results to fn in file order
+	var err error
+	for slot := range slots {
+		if err = fn(<-slot); err != nil {
+			break
+		}
+	}
+	close(done)
+	<-readerDone
+	wg.Wait()
+
+	if err == errStopRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return readErr
+}
+
+// each calculates the remaining data rows one at a time and calls fn with every row
+func (r *csvRows) each(fn func(resultRow) error) error {
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			if err == errStopRows {
+				return nil
+			}
+			return err
+		}
+	}
+}
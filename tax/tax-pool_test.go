@@ -0,0 +1,177 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newBenchmarkRecords returns a header row and n data rows, every 100th row invalid.
+func newBenchmarkRecords(n int) [][]string {
+	records := [][]string{{"totalIncome", "wht", "donation", "k-receipt"}}
+	for i := 0; i < n; i++ {
+		if i%100 == 99 {
+			records = append(records, []string{"invalid", "0", "0", "0"})
+			continue
+		}
+		income := 100000 + (i%5000)*1000
+		records = append(records, []string{fmt.Sprint(income), fmt.Sprint(income / 20), "20000", "30000"})
+	}
+	return records
+}
+
+func TestWorkersFromEnv(t *testing.T) {
+	t.Setenv("TAX_WORKERS", "")
+	workers, err := WorkersFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultWorkers(), workers)
+
+	t.Setenv("TAX_WORKERS", "3")
+	workers, err = WorkersFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, workers)
+
+	t.Setenv("TAX_WORKERS", "-1")
+	_, err = WorkersFromEnv()
+	assert.Error(t, err)
+}
+
+func TestCSVRowsEachPreservesOrder(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	records := newBenchmarkRecords(5000)
+
+	collect := func(workers int) []resultRow {
+		rows, err := newCSVRows(&sliceRecordReader{records: records}, rules, DecimalSeparatorAuto)
+		assert.NoError(t, err)
+		var collected []resultRow
+		assert.NoError(t, rows.Each(workers, func(row resultRow) error {
+			collected = append(collected, row)
+			return nil
+		}))
+		return collected
+	}
+
+	expected := collect(1)
+	assert.Len(t, expected, 5000)
+	for _, workers := range []int{2, 8, 32} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			assert.Equal(t, expected, collect(workers))
+		})
+	}
+}
+
+func TestCSVRowsEachStops(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	records := newBenchmarkRecords(1000)
+	failed := errors.New("write failed")
+
+	tests := []struct {
+		name     string
+		stopErr  error
+		expected error
+	}{
+		{name: "stop", stopErr: errStopRows, expected: nil},
+		{name: "error", stopErr: failed, expected: failed},
+	}
+
+	for _, tt := range tests {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s with %d workers", tt.name, workers), func(t *testing.T) {
+				rows, err := newCSVRows(&sliceRecordReader{records: records}, rules, DecimalSeparatorAuto)
+				assert.NoError(t, err)
+
+				var count int
+				err = rows.Each(workers, func(row resultRow) error {
+					count++
+					if count == 10 {
+						return tt.stopErr
+					}
+					return nil
+				})
+				assert.Equal(t, tt.expected, err)
+				assert.Equal(t, 10, count)
+			})
+		}
+	}
+}
+
+func TestCSVRowsEachReadError(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+
+	reader := csv.NewReader(bytes.NewBufferString("totalIncome\n500000\n\"unterminated\n"))
+	rows, err := newCSVRows(csvRecordReader{Reader: reader, Closer: io.NopCloser(nil)}, rules, DecimalSeparatorAuto)
+	assert.NoError(t, err)
+
+	var count int
+	err = rows.Each(4, func(row resultRow) error {
+		count++
+		return nil
+	})
+	var parseErr *csv.ParseError
+	assert.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 1, count)
+}
+
+// BenchmarkCSVRowsEach measures the throughput of calculating 100k parsed rows.
+func BenchmarkCSVRowsEach(b *testing.B) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	if err != nil {
+		b.Fatal(err)
+	}
+	records := newBenchmarkRecords(100000)
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rows, err := newCSVRows(&sliceRecordReader{records: records}, rules, DecimalSeparatorAuto)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := rows.Each(workers, func(resultRow) error { return nil }); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*(len(records)-1))/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
+
+// BenchmarkCSVRowsEachFromCSV measures the throughput of parsing and calculating a 100k-row CSV file.
+func BenchmarkCSVRowsEachFromCSV(b *testing.B) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	if err != nil {
+		b.Fatal(err)
+	}
+	records := newBenchmarkRecords(100000)
+	var content bytes.Buffer
+	writer := csv.NewWriter(&content)
+	if err := writer.WriteAll(records); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(content.Len()))
+			for i := 0; i < b.N; i++ {
+				reader := csv.NewReader(bytes.NewReader(content.Bytes()))
+				reader.FieldsPerRecord = -1
+				rows, err := newCSVRows(csvRecordReader{Reader: reader, Closer: io.NopCloser(nil)}, rules, DecimalSeparatorAuto)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := rows.Each(workers, func(resultRow) error { return nil }); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*(len(records)-1))/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
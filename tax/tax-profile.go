@@ -0,0 +1,122 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrInvalidProfile is returned when a taxpayer profile field is rejected.
+var ErrInvalidProfile = errors.New("invalid taxpayer profile")
+
+// Marital statuses of a taxpayer
+const (
+	MaritalStatusSingle  = "single"
+	MaritalStatusMarried = "married"
+)
+
+// Deduction types of the family allowances derived from a TaxpayerProfile
+const (
+	DeductionSpouse            = "spouse"
+	DeductionChild             = "child"
+	DeductionParent            = "parent"
+	DeductionDisabledDependant = "disabled-dependant"
+)
+
+// Parameters of the family allowances of section 47 of the Revenue Code
+const (
+	spouseAllowance            = 60000 * Baht // spouseAllowance is deducted for a spouse without income
+	childAllowance             = 30000 * Baht // childAllowance is deducted for every child
+	secondChildAllowance       = 60000 * Baht // secondChildAllowance is deducted from the second child born in secondChildBonusFrom
+	secondChildBonusFrom       = 2561         // secondChildBonusFrom is the Buddhist Era year of birth from which the second child bonus applies
+	parentAllowance            = 30000 * Baht // parentAllowance is deducted for every parent aged 60 or over
+	maxParents                 = 4            // maxParents counts the parents of the taxpayer and of the spouse
+	disabledDependantAllowance = 60000 * Baht // disabledDependantAllowance is deducted for every disabled dependant
+	maxDisabledDependants      = 20           // maxDisabledDependants bounds the disabled dependants of a profile
+	maxChildren                = 20           // maxChildren bounds the children of a profile
+	maxChildAge                = 100          // maxChildAge rejects birth years that are not Buddhist Era years
+)
+
+// Child represents a child of the taxpayer by the Buddhist Era year of birth.
+type Child struct {
+	BirthYear int `json:"birthYear"`
+}
+
+// TaxpayerProfile represents the family of the taxpayer, from which the engine derives
+// the spouse, child, parent and disabled dependant allowances. Parents counts the
+// parents of the taxpayer and of the spouse aged 60 or over and supported by the taxpayer.
+type TaxpayerProfile struct {
+	MaritalStatus       string  `json:"maritalStatus"`
+	SpouseWithoutIncome bool    `json:"spouseWithoutIncome"`
+	Children            []Child `json:"children"`
+	Parents             int     `json:"parents"`
+	DisabledDependants  int     `json:"disabledDependants"`
+}
+
+// Validate checks the fields of the profile for a tax year.
+func (p TaxpayerProfile) Validate(taxYear int) error {
+	switch p.MaritalStatus {
+	case "", MaritalStatusSingle, MaritalStatusMarried:
+	default:
+		err := fmt.Errorf("%w: maritalStatus must be %q or %q", ErrInvalidProfile, MaritalStatusSingle, MaritalStatusMarried)
+		return fieldError(err, "/profile/maritalStatus", err.Error())
+	}
+	if p.SpouseWithoutIncome && p.MaritalStatus != MaritalStatusMarried {
+		err := fmt.Errorf("%w: spouseWithoutIncome requires maritalStatus %q", ErrInvalidProfile, MaritalStatusMarried)
+		return fieldError(err, "/profile/spouseWithoutIncome", err.Error())
+	}
+	if len(p.Children) > maxChildren {
+		err := fmt.Errorf("%w: children must not list more than %d children", ErrInvalidProfile, maxChildren)
+		return fieldError(err, "/profile/children", err.Error())
+	}
+	for n, child := range p.Children {
+		if child.BirthYear > taxYear || child.BirthYear < taxYear-maxChildAge {
+			err := fmt.Errorf("%w: birthYear must be a Buddhist Era year from %d to %d", ErrInvalidProfile, taxYear-maxChildAge, taxYear)
+			return fieldError(err, fmt.Sprintf("/profile/children/%d/birthYear", n), err.Error())
+		}
+	}
+	if p.Parents < 0 || p.Parents > maxParents {
+		err := fmt.Errorf("%w: parents must be from 0 to %d", ErrInvalidProfile, maxParents)
+		return fieldError(err, "/profile/parents", err.Error())
+	}
+	if p.DisabledDependants < 0 || p.DisabledDependants > maxDisabledDependants {
+		err := fmt.Errorf("%w: disabledDependants must be from 0 to %d", ErrInvalidProfile, maxDisabledDependants)
+		return fieldError(err, "/profile/disabledDependants", err.Error())
+	}
+	return nil
+}
+
+// Deductions returns the family allowances of the profile, leaving out allowances of 0.
+// Children are counted in order of birth; from the second child, children born in
+// secondChildBonusFrom or later are deducted at secondChildAllowance.
+func (p TaxpayerProfile) Deductions() []Deduction {
+	var deductions []Deduction
+	add := func(deductionType string, amount Money) {
+		if amount > 0 {
+			deductions = append(deductions, Deduction{Type: deductionType, Amount: amount})
+		}
+	}
+
+	if p.MaritalStatus == MaritalStatusMarried && p.SpouseWithoutIncome {
+		add(DeductionSpouse, spouseAllowance)
+	}
+
+	years := make([]int, 0, len(p.Children))
+	for _, child := range p.Children {
+		years = append(years, child.BirthYear)
+	}
+	sort.Ints(years)
+	var children Money
+	for i, year := range years {
+		if i > 0 && year >= secondChildBonusFrom {
+			children += secondChildAllowance
+			continue
+		}
+		children += childAllowance
+	}
+	add(DeductionChild, children)
+
+	add(DeductionParent, parentAllowance*Money(p.Parents))
+	add(DeductionDisabledDependant, disabledDependantAllowance*Money(p.DisabledDependants))
+	return deductions
+}
@@ -0,0 +1,88 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaxpayerProfileDeductions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		profile  TaxpayerProfile
+		expected []Deduction
+	}{
+		{"single without dependants", TaxpayerProfile{MaritalStatus: MaritalStatusSingle}, nil},
+		{"married with spouse without income", TaxpayerProfile{MaritalStatus: MaritalStatusMarried, SpouseWithoutIncome: true}, []Deduction{{DeductionSpouse, 60000 * Baht}}},
+		{"married with spouse with income", TaxpayerProfile{MaritalStatus: MaritalStatusMarried}, nil},
+		{"second child born from 2561", TaxpayerProfile{Children: []Child{{2563}, {2558}}}, []Deduction{{DeductionChild, 90000 * Baht}}},
+		{"second child born before 2561", TaxpayerProfile{Children: []Child{{2555}, {2558}}}, []Deduction{{DeductionChild, 60000 * Baht}}},
+		{"first child born from 2561", TaxpayerProfile{Children: []Child{{2562}}}, []Deduction{{DeductionChild, 30000 * Baht}}},
+		{"parents and disabled dependants", TaxpayerProfile{Parents: 3, DisabledDependants: 2}, []Deduction{{DeductionParent, 90000 * Baht}, {DeductionDisabledDependant, 120000 * Baht}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.NoError(t, tc.profile.Validate(DefaultTaxYear))
+			assert.Equal(t, tc.expected, tc.profile.Deductions())
+		})
+	}
+}
+
+func TestTaxpayerProfileValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		profile TaxpayerProfile
+		pointer string
+	}{
+		{"unknown marital status", TaxpayerProfile{MaritalStatus: "engaged"}, "/profile/maritalStatus"},
+		{"spouse of a single taxpayer", TaxpayerProfile{MaritalStatus: MaritalStatusSingle, SpouseWithoutIncome: true}, "/profile/spouseWithoutIncome"},
+		{"child born after the tax year", TaxpayerProfile{Children: []Child{{2560}, {2568}}}, "/profile/children/1/birthYear"},
+		{"child birth year not in Buddhist Era", TaxpayerProfile{Children: []Child{{2019}}}, "/profile/children/0/birthYear"},
+		{"too many parents", TaxpayerProfile{Parents: 5}, "/profile/parents"},
+		{"negative disabled dependants", TaxpayerProfile{DisabledDependants: -1}, "/profile/disabledDependants"},
+		{"too many disabled dependants", TaxpayerProfile{DisabledDependants: 1 << 40}, "/profile/disabledDependants"},
+		{"too many children", TaxpayerProfile{Children: make([]Child, maxChildren+1)}, "/profile/children"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.profile.Validate(DefaultTaxYear)
+			assert.ErrorIs(t, err, ErrInvalidProfile)
+			var validationErr *ValidationError
+			if assert.ErrorAs(t, err, &validationErr) {
+				assert.Equal(t, tc.pointer, validationErr.Fields[0].Pointer)
+			}
+		})
+	}
+}
+
+func TestCalculateWithTaxpayerProfile(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+
+	response, err := rules.Calculate(CalculationRequest{
+		TotalIncome: 1000000 * Baht,
+		Allowances:  []Allowance{{AllowanceType: "donation", Amount: 100000 * Baht}},
+		Profile: &TaxpayerProfile{
+			MaritalStatus:       MaritalStatusMarried,
+			SpouseWithoutIncome: true,
+			Children:            []Child{{2558}, {2562}, {2563}},
+			Parents:             2,
+			DisabledDependants:  1,
+		},
+	})
+	assert.NoError(t, err)
+
+	// Donations are capped at 10% of the income after the family allowances
+	assert.Equal(t, []Deduction{
+		{SettingPersonalDeduction, 60000 * Baht},
+		{DeductionSpouse, 60000 * Baht},
+		{DeductionChild, 150000 * Baht},
+		{DeductionParent, 60000 * Baht},
+		{DeductionDisabledDependant, 60000 * Baht},
+		{"donation", 61000 * Baht},
+	}, response.Deductions)
+	assert.Equal(t, 549000*Baht, response.TaxableIncome)
+	assert.Equal(t, 42350*Baht, response.Tax)
+}
@@ -1,7 +1,7 @@
 package tax
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -10,109 +10,312 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-// TaxData represents tax-related data from the CSV file
+// TaxData represents tax-related data of one row of the CSV file
 type TaxData struct {
-	TotalIncome float64 `csv:"totalIncome"`
-	WHT         float64 `csv:"wht"`
-	Donation    float64 `csv:"donation"`
+	TotalIncome Money
+	WHT         Money
+	Allowances  []Allowance
 }
 
 // TaxCalculation represents the calculated tax for a set of tax data
 type TaxCalculation struct {
-	TotalIncome float64 `json:"totalIncome"`
-	Tax         float64 `json:"tax"`
+	TotalIncome Money `json:"totalIncome"`
+	Tax         Money `json:"tax"`
+	// TaxCalculationDetail is nil in the lean form of the result
+	*TaxCalculationDetail
 }
 
-// ReadCSVHandler parses the CSV file from the request body
-func ReadCSVHandler(c echo.Context) ([][]string, error) {
-	// Parse the CSV file from the request body
-	file, err := c.FormFile("taxFile")
-	if err != nil {
-		return nil, err
+// TaxCalculationDetail represents the full calculation behind a TaxCalculation
+type TaxCalculationDetail struct {
+	TaxRefund     Money       `json:"taxRefund"`
+	TaxableIncome Money       `json:"taxableIncome"`
+	Deductions    []Deduction `json:"deductions"`
+	TaxLevel      []TaxLevel  `json:"taxLevel"`
+}
+
+// Lean returns the calculation without its detail
+func (t TaxCalculation) Lean() TaxCalculation {
+	t.TaxCalculationDetail = nil
+	return t
+}
+
+// CSVRowError describes why one row of a CSV file could not be calculated
+type CSVRowError struct {
+	Row    int    `json:"row"`              // Row is the 1-based record number, counting the header
+	Column string `json:"column,omitempty"` // Column is the offending column, empty when the whole row is invalid
+	Value  string `json:"value,omitempty"`  // Value is the raw value of the offending column
+	Reason string `json:"reason"`
+}
+
+// Error returns the row, column and reason of the error
+func (e *CSVRowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Reason)
 	}
+	return fmt.Sprintf("row %d, column %s: %s", e.Row, e.Column, e.Reason)
+}
 
-	// Open the uploaded CSV file
-	src, err := file.Open()
-	if err != nil {
-		return nil, err
+// CSVRowResult represents the calculated tax of one row of a CSV file
+type CSVRowResult struct {
+	Row int `json:"row"`
+	TaxCalculation
+}
+
+// Modes for handling invalid rows of an uploaded CSV file
+const (
+	CSVOnErrorAbort    = "abort"    // Reject the whole file at the first invalid row
+	CSVOnErrorContinue = "continue" // Calculate every valid row and report the invalid ones
+)
+
+// Names of the CSV columns that are not allowance types
+const (
+	CSVColumnTotalIncome = "totalIncome"
+	CSVColumnWHT         = "wht"
+)
+
+// legacyCSVColumns are the columns of a CSV file without a header row
+var legacyCSVColumns = []string{CSVColumnTotalIncome, CSVColumnWHT, "donation"}
+
+// csvLayout maps the columns of a CSV file to tax data
+type csvLayout struct {
+	columns []string // columns holds the name of every column in file order
+	first   int      // first is the index of the first data record, 1 after a header row
+	decimal string   // decimal is the decimal separator of the amounts, see ParseAmount
+}
+
+// parseCSVHeader returns the layout of a CSV file from its first record. The header row
+// names the columns in any order: totalIncome is required, wht and registered allowance
+// types are optional. Files without a header row use the legacy totalIncome,wht,donation layout.
+// Amounts are parsed with the decimal separator decimal.
+func parseCSVHeader(first []string, decimal string) (csvLayout, error) {
+	// A first record starting with an amount is data, not a header
+	if _, err := ParseAmount(first[0], decimal); err == nil {
+		return csvLayout{columns: legacyCSVColumns, decimal: decimal}, nil
 	}
-	defer src.Close()
 
-	// Parse the CSV file
-	reader := csv.NewReader(src)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, err
+	layout := csvLayout{first: 1, decimal: decimal}
+	seen := map[string]bool{}
+	for _, cell := range first {
+		name := strings.TrimSpace(cell)
+		if name == "" {
+			return csvLayout{}, &CSVRowError{Row: 1, Reason: "invalid CSV format: empty column name"}
+		}
+		if seen[name] {
+			return csvLayout{}, &CSVRowError{Row: 1, Column: name, Reason: "invalid CSV format: duplicate column"}
+		}
+		if _, ok := LookupAllowanceRule(name); !ok && name != CSVColumnTotalIncome && name != CSVColumnWHT {
+			return csvLayout{}, &CSVRowError{Row: 1, Column: name, Reason: "invalid CSV format: unknown column"}
+		}
+		seen[name] = true
+		layout.columns = append(layout.columns, name)
+	}
+	if !seen[CSVColumnTotalIncome] {
+		return csvLayout{}, &CSVRowError{Row: 1, Column: CSVColumnTotalIncome, Reason: "invalid CSV format: missing column"}
 	}
 
-	return records, nil
+	return layout, nil
 }
 
-// CalculateTaxFromCSV calculates tax from CSV records
-func CalculateTaxFromCSV(records [][]string) ([]TaxCalculation, error) {
-	var taxCalculations []TaxCalculation
-	for _, record := range records {
-		// Cheak csv format
-		if strings.Contains(record[0], "totalIncome") || strings.Contains(record[0], "wht") || strings.Contains(record[0], "donation") {
-			if record[0] == "totalIncome" || record[1] == "wht" || record[2] == "donation" {
+// parseRecord parses one data record into tax data. sources holds the index of the
+// column each allowance was read from. Empty wht and allowance cells are skipped; amounts
+// may be formatted as accepted by ParseAmount. Negative totalIncome and wht are rejected.
+func (l csvLayout) parseRecord(record []string, row int) (TaxData, []int, *CSVRowError) {
+	if len(record) != len(l.columns) {
+		return TaxData{}, nil, &CSVRowError{
+			Row:    row,
+			Reason: fmt.Sprintf("invalid CSV format: expected %d columns, got %d", len(l.columns), len(record)),
+		}
+	}
+
+	var data TaxData
+	var sources []int
+	for i, name := range l.columns {
+		value := strings.TrimSpace(record[i])
+		switch name {
+		case CSVColumnTotalIncome:
+			totalIncome, err := ParseAmount(value, l.decimal)
+			if err != nil {
+				return TaxData{}, nil, &CSVRowError{Row: row, Column: name, Value: record[i], Reason: "invalid totalIncome"}
+			}
+			if totalIncome < 0 {
+				return TaxData{}, nil, &CSVRowError{Row: row, Column: name, Value: record[i], Reason: "totalIncome must not be negative"}
+			}
+			data.TotalIncome = totalIncome
+		case CSVColumnWHT:
+			if value == "" {
+				continue
+			}
+			wht, err := ParseAmount(value, l.decimal)
+			if err != nil {
+				return TaxData{}, nil, &CSVRowError{Row: row, Column: name, Value: record[i], Reason: "invalid WHT"}
+			}
+			if wht < 0 {
+				return TaxData{}, nil, &CSVRowError{Row: row, Column: name, Value: record[i], Reason: "wht must not be negative"}
+			}
+			data.WHT = wht
+		default:
+			if value == "" {
 				continue
-			} else {
-				return nil, fmt.Errorf("invalid CSV format")
 			}
+			amount, err := ParseAmount(value, l.decimal)
+			if err != nil {
+				return TaxData{}, nil, &CSVRowError{Row: row, Column: name, Value: record[i], Reason: "invalid " + name}
+			}
+			data.Allowances = append(data.Allowances, Allowance{AllowanceType: name, Amount: amount})
+			sources = append(sources, i)
 		}
+	}
 
-		// Validate and parse the record
-		if len(record) != 3 {
-			return nil, fmt.Errorf("invalid CSV format")
-		}
+	return data, sources, nil
+}
 
-		totalIncomeStr := strings.TrimSpace(record[0])
-		totalIncomeStr = strings.ReplaceAll(totalIncomeStr, ",", ".")
-		totalIncome, err := strconv.ParseFloat(totalIncomeStr, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid totalIncome")
-		}
+// calculateRecord calculates the tax of one CSV data record
+func (l csvLayout) calculateRecord(record []string, row int, rules RuleSet) (TaxCalculation, *CSVRowError) {
+	data, sources, rowErr := l.parseRecord(record, row)
+	if rowErr != nil {
+		return TaxCalculation{}, rowErr
+	}
 
-		wht, err := strconv.ParseFloat(record[1], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid WHT")
-		}
+	// Calculate tax using the rules of the rule set
+	taxResponse, err := rules.Calculate(data.request())
+	if err != nil {
+		return TaxCalculation{}, l.rowError(err, row, record, sources)
+	}
+
+	return TaxCalculation{
+		TotalIncome: data.TotalIncome,
+		Tax:         taxResponse.Tax,
+		TaxCalculationDetail: &TaxCalculationDetail{
+			TaxRefund:     taxResponse.TaxRefund,
+			TaxableIncome: taxResponse.TaxableIncome,
+			Deductions:    taxResponse.Deductions,
+			TaxLevel:      taxResponse.TaxLevel,
+		},
+	}, nil
+}
 
-		donation, err := strconv.ParseFloat(record[2], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid donation")
+// checkRecord parses one data record and checks it as the calculation does, without
+// calculating its tax
+func (l csvLayout) checkRecord(record []string, row int, rules RuleSet) (TaxData, *CSVRowError) {
+	data, sources, rowErr := l.parseRecord(record, row)
+	if rowErr != nil {
+		return TaxData{}, rowErr
+	}
+	if err := rules.Check(data.request()); err != nil {
+		return TaxData{}, l.rowError(err, row, record, sources)
+	}
+	return data, nil
+}
+
+// request returns the calculation request of the tax data of a row
+func (d TaxData) request() CalculationRequest {
+	return CalculationRequest{TotalIncome: d.TotalIncome, WHT: d.WHT, Allowances: d.Allowances}
+}
+
+// rowError returns the *CSVRowError of a record rejected by the calculation, pointing at
+// the column of the rejected request field
+func (l csvLayout) rowError(err error, row int, record []string, sources []int) *CSVRowError {
+	rowErr := &CSVRowError{Row: row, Reason: err.Error()}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) && len(validationErr.Fields) > 0 {
+		if column := l.columnOf(validationErr.Fields[0].Pointer, sources); column >= 0 {
+			rowErr.Column, rowErr.Value = l.columns[column], record[column]
 		}
+	}
+	return rowErr
+}
 
-		// Calculate tax using the existing CalculateTax function
-		taxResponse, err := CalculateTax(totalIncome, wht, []Allowance{{AllowanceType: "donation", Amount: donation}}, PersonalDeduction)
-		if err != nil {
-			return nil, err
+// columnOf returns the index of the CSV column holding the request field
+// identified by a JSON pointer, or -1 if there is none
+func (l csvLayout) columnOf(pointer string, sources []int) int {
+	var n int
+	if _, err := fmt.Sscanf(pointer, "/allowances/%d/", &n); err == nil && n >= 0 && n < len(sources) {
+		return sources[n]
+	}
+	for i, name := range l.columns {
+		if pointer == "/"+name {
+			return i
 		}
+	}
+	return -1
+}
+
+// CalculateTaxFromCSVHandler handles CSV parsing and tax calculation.
+func (h *Handler) CalculateTaxFromCSVHandler(c echo.Context) error {
+	upload, err := h.readUpload(c)
+	if err != nil {
+		return uploadFailed(c, err)
+	}
+	calculation := bulkCalculation{
+		options: upload.options,
+		rules:   upload.rules,
+		maxRows: h.limits.MaxRows,
+		workers: h.workers,
+		open:    upload.open,
+	}
 
-		// Append tax calculation to the response
-		taxCalculations = append(taxCalculations, TaxCalculation{
-			TotalIncome: totalIncome,
-			Tax:         taxResponse.Tax,
-		})
+	// Check the whole file before the response is committed
+	if err := calculation.check(); err != nil {
+		return uploadFailed(c, err)
 	}
 
-	return taxCalculations, nil
+	// Stream the results of every row
+	writeResultHeader(c, upload.options.Format)
+	return calculation.write(c.Response(), nil)
 }
 
-// CalculateTaxFromCSV handles CSV parsing and tax calculation
-func CalculateTaxFromCSVHandler(c echo.Context) error {
-	// Parse CSV
-	records, err := ReadCSVHandler(c)
-	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("Error parsing CSV file: %v", err))
+// uploadFailed writes the ErrorResponse of an uploaded file that could not be read or calculated
+func uploadFailed(c echo.Context, err error) error {
+	status, response := uploadError(err)
+	return c.JSON(status, response)
+}
+
+// uploadError returns the status and the ErrorResponse of an uploaded file that could not
+// be read or calculated
+func uploadError(err error) (int, ErrorResponse) {
+	var maxBytesErr *http.MaxBytesError
+	var tooManyRowsErr *tooManyRowsError
+	var validationErr *ValidationError
+	var rowErr *CSVRowError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest, ErrorResponse{Code: ErrorCodeValidation, Message: validationErr.Error(), Fields: validationErr.Fields}
+	case errors.As(err, &maxBytesErr):
+		message := fmt.Sprintf("Error parsing tax file: request is larger than %d bytes", maxBytesErr.Limit)
+		return http.StatusRequestEntityTooLarge, fileErrorResponse(ErrorCodeFileTooLarge, message)
+	case errors.As(err, &tooManyRowsErr):
+		message := fmt.Sprintf("Error calculating tax: %v", err)
+		return http.StatusRequestEntityTooLarge, fileErrorResponse(ErrorCodeFileTooLarge, message)
+	case errors.Is(err, ErrSheetNotFound):
+		message := fmt.Sprintf("Invalid value for sheet: %v", err)
+		return http.StatusBadRequest, ErrorResponse{Code: ErrorCodeValidation, Message: message, Fields: []FieldError{{Parameter: "sheet", Message: message}}}
+	case errors.As(err, &rowErr):
+		message := fmt.Sprintf("Error calculating tax: %v", err)
+		return http.StatusBadRequest, fileErrorResponse(ErrorCodeInvalidFile, message)
 	}
+	message := fmt.Sprintf("Error parsing tax file: %v", err)
+	return http.StatusBadRequest, fileErrorResponse(ErrorCodeInvalidFile, message)
+}
 
-	// Calculate tax
-	taxCalculations, err := CalculateTaxFromCSV(records)
-	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("Error calculating tax: %v", err))
+// fileErrorResponse returns an ErrorResponse pointing at the uploaded file
+func fileErrorResponse(code string, message string) ErrorResponse {
+	return ErrorResponse{Code: code, Message: message, Fields: []FieldError{{Parameter: "taxFile", Message: message}}}
+}
+
+// parseTaxYear parses the optional taxYear form field, returning 0 when it is empty
+func parseTaxYear(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
 	}
+	return strconv.Atoi(value)
+}
 
-	// Return the calculated taxes as JSON
-	return c.JSON(http.StatusOK, map[string]interface{}{"taxes": taxCalculations})
+// parseDetail parses the optional detail query parameter, returning false when it is empty
+func parseDetail(value string) (bool, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
 }
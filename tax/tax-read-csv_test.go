@@ -16,7 +16,50 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestReadCSVHandler(t *testing.T) {
+// calculateRecords calculates CSV records with the row pipeline of the bulk upload,
+// returning the results of the valid rows and the errors of the invalid rows
+func calculateRecords(records [][]string, rules RuleSet) ([]CSVRowResult, []CSVRowError, error) {
+	rows, err := newCSVRows(&sliceRecordReader{records: records}, rules, DecimalSeparatorAuto)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := []CSVRowResult{}
+	rowErrors := []CSVRowError{}
+	err = rows.Each(DefaultWorkers(), func(row resultRow) error {
+		if row.err != nil {
+			rowErrors = append(rowErrors, *row.err)
+			return nil
+		}
+		results = append(results, CSVRowResult{Row: row.row, TaxCalculation: *row.result})
+		return nil
+	})
+	return results, rowErrors, err
+}
+
+func TestCalculateTaxFromCSV(t *testing.T) {
+	// Sample records
+	records := [][]string{
+		{"totalIncome", "wht", "donation"},
+		{"500000", "0", "0"},
+		{"600000", "40000", "20000"},
+		{"750000", "50000", "15000"},
+	}
+
+	// Calculate the records
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	results, rowErrors, err := calculateRecords(records, rules)
+
+	// Check if there's no error
+	assert.NoError(t, err)
+	assert.Empty(t, rowErrors)
+
+	// Check the number of tax calculations
+	assert.Equal(t, 3, len(results))
+}
+
+func TestCalculateTaxFromCSVHandler(t *testing.T) {
 	// Create a new echo instance
 	e := echo.New()
 
@@ -80,109 +123,315 @@ func TestReadCSVHandler(t *testing.T) {
 	// Create a context with the request and response recorder
 	c := e.NewContext(req, rec)
 
-	// Call the ReadCSVHandler function
-	records, err := ReadCSVHandler(c)
+	// Call the CalculateTaxFromCSVHandler function
+	err = newTestHandler(t).CalculateTaxFromCSVHandler(c)
 
 	// Check if there's no error
 	assert.NoError(t, err)
 
-	// Check the number of records
-	assert.Equal(t, 4, len(records)) // Excluding header row
+	// Check the status code
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Check the response body
+	expectedResponseBody := `{"taxes":[{"totalIncome":500000,"tax":29000},{"totalIncome":600000,"tax":0},{"totalIncome":750000,"tax":11250}]}`
+	assert.Equal(t, expectedResponseBody, strings.TrimSpace(rec.Body.String()))
 }
 
-func TestCalculateTaxFromCSV(t *testing.T) {
-	// Sample records
+// newUploadRequest creates a multipart request uploading content as the taxFile
+// field, with the given extra form fields.
+func newUploadRequest(t *testing.T, target string, filename string, content []byte, fields map[string]string) *http.Request {
+	t.Helper()
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part, err := writer.CreateFormFile("taxFile", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, target, body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	return req
+}
+
+func TestCalculateTaxFromCSVHandlerErrorResponse(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,abc,0\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte(csvContent), nil)
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"invalid_file"`)
+}
+
+func TestCalculateTaxFromCSVRowError(t *testing.T) {
 	records := [][]string{
 		{"totalIncome", "wht", "donation"},
 		{"500000", "0", "0"},
-		{"600000", "40000", "20000"},
-		{"750000", "50000", "15000"},
+		{"600000", "abc", "0"},
 	}
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
 
-	// Call the CalculateTaxFromCSV function
-	taxCalculations, err := CalculateTaxFromCSV(records)
+	_, rowErrors, err := calculateRecords(records, rules)
+	assert.NoError(t, err)
+	assert.Equal(t, []CSVRowError{{Row: 3, Column: "wht", Value: "abc", Reason: "invalid WHT"}}, rowErrors)
+}
 
-	// Check if there's no error
+func TestCalculateTaxFromCSVRows(t *testing.T) {
+	records := [][]string{
+		{"totalIncome", "wht", "donation"},
+		{"500000", "0", "0"},
+		{"x", "0", "0"},
+		{"600000", "40000", "20000"},
+		{"100000", "200000", "0"},
+		{"100000", "0", "-5"},
+		{"100000", "0"},
+		{"750000", "50000", "15000"},
+	}
+	rules, err := RuleSetForYear(DefaultTaxYear)
 	assert.NoError(t, err)
 
-	// Check the number of tax calculations
-	assert.Equal(t, 3, len(taxCalculations))
+	results, rowErrors, err := calculateRecords(records, rules)
+	assert.NoError(t, err)
+	for i := range results {
+		results[i].TaxCalculation = results[i].Lean()
+	}
+	assert.Equal(t, []CSVRowResult{
+		{Row: 2, TaxCalculation: TaxCalculation{TotalIncome: 500000 * Baht, Tax: 29000 * Baht}},
+		{Row: 4, TaxCalculation: TaxCalculation{TotalIncome: 600000 * Baht, Tax: 0}},
+		{Row: 8, TaxCalculation: TaxCalculation{TotalIncome: 750000 * Baht, Tax: 11250 * Baht}},
+	}, results)
+
+	if assert.Len(t, rowErrors, 4) {
+		assert.Equal(t, CSVRowError{Row: 3, Column: "totalIncome", Value: "x", Reason: "invalid totalIncome"}, rowErrors[0])
+		assert.Equal(t, 5, rowErrors[1].Row)
+		assert.Equal(t, "wht", rowErrors[1].Column)
+		assert.Equal(t, "200000", rowErrors[1].Value)
+		assert.Equal(t, 6, rowErrors[2].Row)
+		assert.Equal(t, "donation", rowErrors[2].Column)
+		assert.Equal(t, "-5", rowErrors[2].Value)
+		assert.Equal(t, CSVRowError{Row: 7, Reason: "invalid CSV format: expected 3 columns, got 2"}, rowErrors[3])
+	}
 }
 
-func TestCalculateTaxFromCSVHandler(t *testing.T) {
-	// Create a new echo instance
+func TestCalculateTaxFromCSVHandlerContinueOnError(t *testing.T) {
 	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,abc,0\n600000,40000,20000\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte(csvContent), map[string]string{"onError": "continue"})
+	rec := httptest.NewRecorder()
 
-	// Create a sample CSV content
-	csvContent := `totalIncome,wht,donation
-	500000,0,0
-	600000,40000,20000
-	750000,50000,15000`
-
-	// Create a temporary file to store the CSV content
-	file, err := ioutil.TempFile("", "test.csv")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.Remove(file.Name()) // Clean up the temporary file
-
-	// Write the CSV content to the temporary file
-	if _, err := file.WriteString(csvContent); err != nil {
-		t.Fatal(err)
-	}
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"taxes": [{"row": 3, "totalIncome": 600000, "tax": 0}],
+		"errors": [{"row": 2, "column": "wht", "value": "abc", "reason": "invalid WHT"}]
+	}`, rec.Body.String())
+}
 
-	// Close the file
-	if err := file.Close(); err != nil {
-		t.Fatal(err)
-	}
+func TestCalculateTaxFromCSVHandlerInvalidOnError(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,0,0\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte(csvContent), map[string]string{"onError": "skip"})
+	rec := httptest.NewRecorder()
 
-	// Open the temporary file for reading
-	file, err = os.Open(file.Name())
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer file.Close()
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"parameter":"onError"`)
+}
 
-	// Create a multipart form writer
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
+func TestCalculateTaxFromCSVHeaderColumns(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
 
-	// Create a form file part
-	part, err := writer.CreateFormFile("taxFile", filepath.Base(file.Name()))
-	if err != nil {
-		t.Fatal(err)
+	tests := []struct {
+		name     string
+		records  [][]string
+		expected []TaxCalculation
+		err      error
+	}{
+		{
+			name: "columns in any order with k-receipt",
+			records: [][]string{
+				{"k-receipt", "totalIncome", "donation"},
+				{"100000", "500000", "0"},
+				{"", "500000", ""},
+			},
+			expected: []TaxCalculation{
+				{TotalIncome: 500000 * Baht, Tax: 24000 * Baht},
+				{TotalIncome: 500000 * Baht, Tax: 29000 * Baht},
+			},
+		},
+		{
+			name: "other registered allowance columns",
+			records: [][]string{
+				{" totalIncome ", "wht", "life-insurance", "social-security"},
+				{"500000", "10000", "40000", "9000"},
+			},
+			expected: []TaxCalculation{
+				{TotalIncome: 500000 * Baht, Tax: 14100 * Baht},
+			},
+		},
+		{
+			name: "legacy file without header",
+			records: [][]string{
+				{"500000", "0", "0"},
+			},
+			expected: []TaxCalculation{
+				{TotalIncome: 500000 * Baht, Tax: 29000 * Baht},
+			},
+		},
+		{
+			name:    "unknown column",
+			records: [][]string{{"totalIncome", "bonus"}, {"500000", "0"}},
+			err:     &CSVRowError{Row: 1, Column: "bonus", Reason: "invalid CSV format: unknown column"},
+		},
+		{
+			name:    "duplicate column",
+			records: [][]string{{"totalIncome", "wht", "wht"}, {"500000", "0", "0"}},
+			err:     &CSVRowError{Row: 1, Column: "wht", Reason: "invalid CSV format: duplicate column"},
+		},
+		{
+			name:    "missing totalIncome",
+			records: [][]string{{"wht", "donation"}, {"0", "0"}},
+			err:     &CSVRowError{Row: 1, Column: "totalIncome", Reason: "invalid CSV format: missing column"},
+		},
+		{
+			name:    "invalid allowance amount",
+			records: [][]string{{"totalIncome", "k-receipt"}, {"500000", "-1"}},
+			err:     &CSVRowError{Row: 2, Column: "k-receipt", Value: "-1", Reason: "invalid allowance amount: k-receipt must not be negative"},
+		},
 	}
 
-	// Copy the file content to the form file part
-	if _, err := io.Copy(part, file); err != nil {
-		t.Fatal(err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, rowErrors, err := calculateRecords(tt.records, rules)
+			if err == nil && len(rowErrors) > 0 {
+				err = &rowErrors[0]
+			}
+			if tt.err != nil {
+				assert.Equal(t, tt.err, err)
+				return
+			}
+			assert.NoError(t, err)
+			var taxCalculations []TaxCalculation
+			for _, result := range results {
+				taxCalculations = append(taxCalculations, result.Lean())
+			}
+			assert.Equal(t, tt.expected, taxCalculations)
+		})
 	}
+}
 
-	// Close the writer
-	if err := writer.Close(); err != nil {
-		t.Fatal(err)
-	}
+func TestCalculateTaxFromCSVHandlerInvalidHeader(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,bonus\n500000,0\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte(csvContent), map[string]string{"onError": "continue"})
+	rec := httptest.NewRecorder()
 
-	// Create a request with the multipart form data
-	req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv", body)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"invalid_file"`)
+	assert.Contains(t, rec.Body.String(), "unknown column")
+}
 
-	// Create a response recorder
+func TestCalculateTaxFromCSVHandlerDetail(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,40000,0\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv?detail=true", "taxes.csv", []byte(csvContent), nil)
 	rec := httptest.NewRecorder()
 
-	// Create a context with the request and response recorder
-	c := e.NewContext(req, rec)
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"taxes": [{
+		"totalIncome": 500000,
+		"tax": 0,
+		"taxRefund": 11000,
+		"taxableIncome": 440000,
+		"deductions": [{"type": "personal", "amount": 60000}, {"type": "donation", "amount": 0}],
+		"taxLevel": [
+			{"level": "0-150,000", "tax": 0},
+			{"level": "150,001-500,000", "tax": 29000},
+			{"level": "500,001-1,000,000", "tax": 0},
+			{"level": "1,000,001-2,000,000", "tax": 0},
+			{"level": "2,000,001 ขึ้นไป", "tax": 0}
+		]
+	}]}`, rec.Body.String())
+}
 
-	// Call the CalculateTaxFromCSVHandler function
-	err = CalculateTaxFromCSVHandler(c)
+func TestCalculateTaxFromCSVHandlerInvalidDetail(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,0,0\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv?detail=maybe", "taxes.csv", []byte(csvContent), nil)
+	rec := httptest.NewRecorder()
 
-	// Check if there's no error
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
 	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"parameter":"detail"`)
+}
 
-	// Check the status code
-	assert.Equal(t, http.StatusOK, rec.Code)
+func TestCalculateTaxFromCSVHandlerFormattedAmounts(t *testing.T) {
+	tests := []struct {
+		name       string
+		csvContent string
+		fields     map[string]string
+	}{
+		{
+			name:       "thousands separators and currency",
+			csvContent: "totalIncome,wht,donation\n\"฿1,250,000.00\",\" 40,000 \",20000 THB\n",
+		},
+		{
+			name:       "decimal comma",
+			csvContent: "totalIncome,wht,donation\n\"1.250.000,00\",40.000,\"20.000,00\"\n",
+			fields:     map[string]string{"decimalSeparator": ","},
+		},
+		{
+			name:       "spaces",
+			csvContent: "totalIncome,wht,donation\n1 250 000,40 000,20 000\n",
+			fields:     map[string]string{"decimalSeparator": "."},
+		},
+	}
 
-	// Check the response body
-	expectedResponseBody := `{"taxes":[{"totalIncome":500000,"tax":29000},{"totalIncome":600000,"tax":0},{"totalIncome":750000,"tax":11250}]}`
-	assert.Equal(t, expectedResponseBody, strings.TrimSpace(rec.Body.String()))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte(tt.csvContent), tt.fields)
+			rec := httptest.NewRecorder()
+
+			err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{"taxes":[{"totalIncome":1250000,"tax":104000}]}`, rec.Body.String())
+		})
+	}
+}
+
+func TestCalculateTaxFromCSVHandlerInvalidDecimalSeparator(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,0,0\n"
+	req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte(csvContent), map[string]string{"decimalSeparator": "'"})
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"parameter":"decimalSeparator"`)
 }
@@ -0,0 +1,33 @@
+package tax
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSheetNotFound is returned when the requested sheet is not in the workbook.
+var ErrSheetNotFound = errors.New("sheet not found")
+
+// xlsxMagic is the signature of the zip container of an XLSX workbook
+var xlsxMagic = []byte("PK\x03\x04")
+
+// maxXLSXUnzipSize limits the unzipped size of an uploaded workbook
+const maxXLSXUnzipSize = 256 << 20
+
+// isXLSX reports whether an uploaded file is an XLSX workbook, by its extension
+// or by the first bytes of its content
+func isXLSX(filename string, head []byte) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".xlsx") || bytes.HasPrefix(head, xlsxMagic)
+}
+
+// isEmptyRow reports whether every cell of a row is blank
+func isEmptyRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
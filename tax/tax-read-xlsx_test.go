@@ -0,0 +1,146 @@
+package tax
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+// newTestWorkbook returns an XLSX workbook with the rows written to each named sheet.
+func newTestWorkbook(t *testing.T, sheets map[string][][]interface{}, order ...string) []byte {
+	t.Helper()
+
+	f := excelize.NewFile()
+	defer f.Close()
+	for i, name := range order {
+		if i == 0 {
+			if err := f.SetSheetName(f.GetSheetName(0), name); err != nil {
+				t.Fatal(err)
+			}
+		} else if _, err := f.NewSheet(name); err != nil {
+			t.Fatal(err)
+		}
+		for r, row := range sheets[name] {
+			cell, err := excelize.CoordinatesToCellName(1, r+1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.SetSheetRow(name, cell, &row); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestIsXLSX(t *testing.T) {
+	assert.True(t, isXLSX("payroll.XLSX", nil))
+	assert.True(t, isXLSX("upload", []byte("PK\x03\x04")))
+	assert.False(t, isXLSX("payroll.csv", []byte("tota")))
+}
+
+func TestCalculateTaxFromXLSXHandler(t *testing.T) {
+	workbook := newTestWorkbook(t, map[string][][]interface{}{
+		"Payroll": {
+			{"totalIncome", "wht", "k-receipt"},
+			{500000, 0, 100000},
+			{},
+			{750000, 50000},
+		},
+		"Bonus": {
+			{"totalIncome"},
+			{600000},
+		},
+	}, "Payroll", "Bonus")
+
+	tests := []struct {
+		name     string
+		filename string
+		fields   map[string]string
+		code     int
+		expected string
+	}{
+		{
+			name:     "first sheet by extension",
+			filename: "payroll.xlsx",
+			code:     http.StatusOK,
+			expected: `{"taxes":[{"totalIncome":500000,"tax":24000},{"totalIncome":750000,"tax":13500}]}`,
+		},
+		{
+			name:     "named sheet by magic bytes",
+			filename: "payroll",
+			fields:   map[string]string{"sheet": "Bonus"},
+			code:     http.StatusOK,
+			expected: `{"taxes":[{"totalIncome":600000,"tax":41000}]}`,
+		},
+		{
+			name:     "unknown sheet",
+			filename: "payroll.xlsx",
+			fields:   map[string]string{"sheet": "Missing"},
+			code:     http.StatusBadRequest,
+			expected: `{"code":"validation_failed","message":"Invalid value for sheet: sheet not found: \"Missing\"","fields":[{"parameter":"sheet","message":"Invalid value for sheet: sheet not found: \"Missing\""}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := newUploadRequest(t, "/tax/calculations/upload-csv", tt.filename, workbook, tt.fields)
+			rec := httptest.NewRecorder()
+
+			err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.code, rec.Code)
+			assert.JSONEq(t, tt.expected, rec.Body.String())
+		})
+	}
+}
+
+func TestReadXLSXRecordsRawValues(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	assert.NoError(t, f.SetSheetRow(sheet, "A1", &[]interface{}{"totalIncome", "wht"}))
+	assert.NoError(t, f.SetSheetRow(sheet, "A2", &[]interface{}{1250000.5, 0}))
+	style, err := f.NewStyle(&excelize.Style{NumFmt: 4}) // #,##0.00
+	assert.NoError(t, err)
+	assert.NoError(t, f.SetCellStyle(sheet, "A2", "A2", style))
+	buf, err := f.WriteToBuffer()
+	assert.NoError(t, err)
+
+	reader, err := openXLSXRecords(buf, "")
+	assert.NoError(t, err)
+	defer reader.Close()
+	var records [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		records = append(records, record)
+	}
+	assert.Equal(t, [][]string{{"totalIncome", "wht"}, {"1250000.5", "0"}}, records)
+}
+
+func TestReadXLSXRecordsInvalidFile(t *testing.T) {
+	e := echo.New()
+	req := newUploadRequest(t, "/tax/calculations/upload-csv", "payroll.xlsx", []byte("totalIncome\n500000\n"), nil)
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"invalid_file"`)
+	assert.Contains(t, rec.Body.String(), "invalid XLSX file")
+}
@@ -0,0 +1,113 @@
+package tax
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// DefaultTaxYear is the tax year used when a calculation does not specify one.
+const DefaultTaxYear = 2567
+
+// ErrUnknownTaxYear is returned when no rule set is registered for a tax year.
+var ErrUnknownTaxYear = errors.New("unknown tax year")
+
+// RuleSet represents the tax rules of one tax year. Donations are capped at 10% of the
+// income after other deductions and, when DonationCap is not 0, at DonationCap.
+type RuleSet struct {
+	TaxYear           int          `json:"taxYear"`
+	Brackets          BracketTable `json:"brackets"`
+	PersonalDeduction Money        `json:"personalDeduction"`
+	DonationCap       Money        `json:"donationCap"`
+	KreceiptCap       Money        `json:"kReceiptCap"`
+}
+
+var (
+	ruleSetsMu sync.RWMutex
+	ruleSets   = map[int]RuleSet{
+		2567: {
+			TaxYear:           2567,
+			Brackets:          DefaultBracketTable,
+			PersonalDeduction: 60000 * Baht,
+			KreceiptCap:       50000 * Baht,
+		},
+	}
+)
+
+// Validate checks that the rule set is complete and consistent.
+func (rs RuleSet) Validate() error {
+	if rs.TaxYear <= 0 {
+		return errors.New("tax year must be positive")
+	}
+	if err := rs.Brackets.Validate(); err != nil {
+		return fmt.Errorf("tax year %d: %w", rs.TaxYear, err)
+	}
+	if rs.PersonalDeduction < 0 || rs.DonationCap < 0 || rs.KreceiptCap < 0 {
+		return fmt.Errorf("tax year %d: deductions must not be negative", rs.TaxYear)
+	}
+	return nil
+}
+
+// RegisterRuleSet adds or replaces the rule set of a tax year.
+func RegisterRuleSet(rs RuleSet) error {
+	if err := rs.Validate(); err != nil {
+		return err
+	}
+
+	ruleSetsMu.Lock()
+	defer ruleSetsMu.Unlock()
+	ruleSets[rs.TaxYear] = rs
+	return nil
+}
+
+// LoadRuleSets registers every rule set of a JSON array read from r.
+func LoadRuleSets(r io.Reader) error {
+	var list []RuleSet
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return fmt.Errorf("decoding rule sets: %w", err)
+	}
+
+	// Validate all rule sets before registering any of them
+	for _, rs := range list {
+		if err := rs.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, rs := range list {
+		if err := RegisterRuleSet(rs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RuleSetForYear returns the rule set of a tax year, or of DefaultTaxYear when year is 0.
+func RuleSetForYear(year int) (RuleSet, error) {
+	if year == 0 {
+		year = DefaultTaxYear
+	}
+
+	ruleSetsMu.RLock()
+	defer ruleSetsMu.RUnlock()
+	rs, ok := ruleSets[year]
+	if !ok {
+		return RuleSet{}, fmt.Errorf("%w: %d", ErrUnknownTaxYear, year)
+	}
+	return rs, nil
+}
+
+// TaxYears returns the registered tax years in ascending order.
+func TaxYears() []int {
+	ruleSetsMu.RLock()
+	defer ruleSetsMu.RUnlock()
+
+	years := make([]int, 0, len(ruleSets))
+	for year := range ruleSets {
+		years = append(years, year)
+	}
+	sort.Ints(years)
+	return years
+}
@@ -0,0 +1,85 @@
+package tax
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleSetForYear(t *testing.T) {
+	// Tax year 0 selects the default tax year
+	rules, err := RuleSetForYear(0)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultTaxYear, rules.TaxYear)
+
+	// Unregistered tax years are rejected
+	_, err = RuleSetForYear(2400)
+	assert.ErrorIs(t, err, ErrUnknownTaxYear)
+}
+
+// restoreRuleSets restores the registered rule sets when the test ends, so rule sets
+// registered by the test do not leak into other tests
+func restoreRuleSets(t *testing.T) {
+	ruleSetsMu.RLock()
+	saved := make(map[int]RuleSet, len(ruleSets))
+	for year, rs := range ruleSets {
+		saved[year] = rs
+	}
+	ruleSetsMu.RUnlock()
+
+	t.Cleanup(func() {
+		ruleSetsMu.Lock()
+		defer ruleSetsMu.Unlock()
+		ruleSets = saved
+	})
+}
+
+func TestLoadRuleSets(t *testing.T) {
+	restoreRuleSets(t)
+	rulesJSON := `[{
+		"taxYear": 2590,
+		"brackets": [
+			{"lower": 0, "upper": 100000, "rate": 0},
+			{"lower": 100000, "upper": 0, "rate": 0.1}
+		],
+		"personalDeduction": 50000,
+		"donationCap": 100000,
+		"kReceiptCap": 50000
+	}]`
+
+	err := LoadRuleSets(strings.NewReader(rulesJSON))
+	assert.NoError(t, err)
+	assert.Contains(t, TaxYears(), 2590)
+
+	rules, err := RuleSetForYear(2590)
+	assert.NoError(t, err)
+
+	// 500,000 - 50,000 = 450,000; (450,000 - 100,000) * 10% = 35,000
+	response, err := rules.Calculate(CalculationRequest{TotalIncome: 500000 * Baht})
+	assert.NoError(t, err)
+	assert.Equal(t, 35000*Baht, response.Tax)
+	assert.Equal(t, 2590, response.TaxYear)
+}
+
+func TestLoadRuleSetsInvalid(t *testing.T) {
+	restoreRuleSets(t)
+	rulesJSON := `[{"taxYear": 2591, "brackets": [{"lower": 10, "upper": 0, "rate": 0.1}]}]`
+
+	err := LoadRuleSets(strings.NewReader(rulesJSON))
+	assert.Error(t, err)
+
+	_, err = RuleSetForYear(2591)
+	assert.ErrorIs(t, err, ErrUnknownTaxYear)
+}
+
+func TestRestoreRuleSets(t *testing.T) {
+	t.Run("register", func(t *testing.T) {
+		restoreRuleSets(t)
+		assert.NoError(t, RegisterRuleSet(RuleSet{TaxYear: 2592, Brackets: DefaultBracketTable}))
+		assert.Contains(t, TaxYears(), 2592)
+	})
+
+	// The rule set registered by the subtest is gone once it has ended
+	assert.NotContains(t, TaxYears(), 2592)
+}
@@ -1,73 +1,151 @@
 package tax
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
 // AdminDeductionRequest represents the request structure for setting personal deduction by admin.
+// EffectiveFrom optionally schedules the change, as an RFC 3339 timestamp or a YYYY-MM-DD date.
 type AdminDeductionRequest struct {
-	Amount float64 `json:"amount"`
+	Amount        Money  `json:"amount"`
+	EffectiveFrom string `json:"effectiveFrom"`
 }
 
 // AdminDeductionResponse by admin.
 type AdminPersonalDeductionResponse struct {
-	PersonalDeduction float64 `json:"personalDeduction"`
+	PersonalDeduction Money      `json:"personalDeduction"`
+	EffectiveFrom     *time.Time `json:"effectiveFrom,omitempty"`
 }
 
 // KreceiptLimitDeductionResponse response by admin.
 type KreceiptLimitDeductionResponse struct {
 	// KreceiptLimitDeduction float64 `json:"kreceiptLimitDeduction"`
-	KreceiptLimitDeduction float64 `json:"kReceipt"`
+	KreceiptLimitDeduction Money      `json:"kReceipt"`
+	EffectiveFrom          *time.Time `json:"effectiveFrom,omitempty"`
 }
 
 // TaxDetailsResponse represents the response structure for tax details.
 type TaxDetailsResponse struct {
-	PersonalDeduction      float64 `json:"personalDeduction"`
-	KreceiptLimitDeduction float64 `json:"kReceipt"`
+	PersonalDeduction      Money `json:"personalDeduction"`
+	KreceiptLimitDeduction Money `json:"kReceipt"`
 	// KReceipt float64 `json:"kReceipt"`
 }
 
-// PersonalDeduction Default .
-var PersonalDeduction float64 = 60000.0
+// Handler serves the tax HTTP endpoints.
+type Handler struct {
+	settings *SettingsService
+	limits   UploadLimits
+	workers  int
+	jobs     *JobService
+}
+
+// NewHandler creates a Handler that reads and writes the admin deduction settings through settings.
+// Uploads are bounded by DefaultUploadLimits and calculated by DefaultWorkers goroutines
+// until SetUploadLimits and SetWorkers are called.
+func NewHandler(settings *SettingsService) *Handler {
+	return &Handler{settings: settings, limits: DefaultUploadLimits(), workers: DefaultWorkers()}
+}
+
+// SetUploadLimits sets the limits of bulk calculation uploads.
+func (h *Handler) SetUploadLimits(limits UploadLimits) {
+	h.limits = limits
+}
+
+// SetWorkers sets the number of goroutines calculating the rows of a bulk upload.
+func (h *Handler) SetWorkers(workers int) {
+	h.workers = workers
+}
+
+// SetJobs sets the service running the bulk calculation jobs.
+func (h *Handler) SetJobs(jobs *JobService) {
+	h.jobs = jobs
+}
+
+// actorFromContext returns the admin username set by the BasicAuth middleware and the request ID.
+func actorFromContext(c echo.Context) Actor {
+	username, _ := c.Get(UsernameContextKey).(string)
+	requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+	if requestID == "" {
+		requestID = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	return Actor{Username: username, RequestID: requestID}
+}
+
+// ruleSet returns the rule set of a tax year with the admin deduction settings applied:
+// those in effect now for DefaultTaxYear, which is also used without a tax year, and
+// those in effect at effectiveDate of any other year.
+func ruleSet(year int, history SettingsHistory, now time.Time) (RuleSet, error) {
+	rules, err := RuleSetForYear(year)
+	if err != nil {
+		return RuleSet{}, err
+	}
+
+	at := now
+	if rules.TaxYear != DefaultTaxYear {
+		at = effectiveDate(rules.TaxYear, now)
+	}
+	return history.At(at, settingsOf(rules)).Apply(rules), nil
+}
+
+// scheduledAt returns the effective date of an admin change and the value to report
+// in its response, which is nil for changes effective immediately. Dates before now
+// are rejected with ErrPastEffectiveFrom.
+func scheduledAt(effectiveFrom string, now time.Time) (time.Time, *time.Time, error) {
+	if effectiveFrom == "" {
+		return time.Time{}, nil, nil
+	}
+	t, err := parseTime(effectiveFrom)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	if t.Before(now) {
+		return time.Time{}, nil, ErrPastEffectiveFrom
+	}
+	return t, &t, nil
+}
 
-// PersonalDeduction Default .
-var KreceiptLimitDeduction float64 = 50000.0
+// settingUpdateFailed writes the response of an admin change the settings service rejected
+func settingUpdateFailed(c echo.Context, name string, err error) error {
+	if errors.Is(err, ErrPastEffectiveFrom) {
+		message := fmt.Sprintf("Invalid value for effectiveFrom: %v", err)
+		return validationFailed(c, message, FieldError{Pointer: "/effectiveFrom", Message: message})
+	}
+	return internalError(c, fmt.Sprintf("Error saving %s: %v", name, err))
+}
 
 // CalculateTaxHandler handles the HTTP request for tax calculation.
-func CalculateTaxHandler(c echo.Context) error {
+func (h *Handler) CalculateTaxHandler(c echo.Context) error {
 	var request CalculationRequest
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid request")
-	}
-
-	var donationDeduction float64
-	var kreceiptDeduction float64
-	for _, allowance := range request.Allowances {
-		switch allowance.AllowanceType {
-		case "donation":
-			donationDeduction = allowance.Amount
-		case "k-receipt":
-			kreceiptDeduction = allowance.Amount
-		}
+	if err := bind(c, &request); err != nil {
+		return bindFailed(c, err)
 	}
 
-	// Check for negative values of PersonalDeduction, donation deduction, and k-receipt deduction
-	if PersonalDeduction < 0 || donationDeduction < 0 || kreceiptDeduction < 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid values for deductions: PersonalDeduction, donation, or k-receipt")
+	// Check for WHT is non-negative and does not exceed total income
+	if request.WHT < 0 || request.WHT > request.grossIncome() {
+		message := "Invalid value for WHT: must be non-negative and not exceed total income"
+		return validationFailed(c, message, FieldError{Pointer: "/wht", Message: message})
 	}
 
-	// Check for WHT is non-negative and does not exceed total income
-	if request.WHT < 0 || request.WHT > request.TotalIncome {
-		return c.JSON(http.StatusBadRequest, "Invalid value for WHT: must be non-negative and not exceed total income")
+	// Select the rule set of the requested tax year
+	rules, err := ruleSet(request.TaxYear, h.settings.Snapshot(), h.settings.Now())
+	if err != nil {
+		message := fmt.Sprintf("Invalid value for taxYear: %v", err)
+		return validationFailed(c, message, FieldError{Pointer: "/taxYear", Message: message})
 	}
 
 	// Calculate tax amount and tax levels
-	response, err := CalculateTax(request.TotalIncome, request.WHT, request.Allowances, PersonalDeduction)
+	response, err := rules.Calculate(request)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationFailed(c, "Invalid request values", validationErr.Fields...)
+	}
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Error calculating tax: %v", err))
+		return internalError(c, fmt.Sprintf("Error calculating tax: %v", err))
 	}
 
 	// Return the response
@@ -75,49 +153,116 @@ func CalculateTaxHandler(c echo.Context) error {
 }
 
 // SetPersonalDeductionHandler handles the HTTP request for setting personal deduction by admin.
-func SetPersonalDeductionHandler(c echo.Context) error {
+func (h *Handler) SetPersonalDeductionHandler(c echo.Context) error {
 	var request AdminDeductionRequest
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid request")
+	if err := bind(c, &request); err != nil {
+		return bindFailed(c, err)
 	}
 
 	// Check if the requested amount is within the allowed range
-	if request.Amount < 10000.0 || request.Amount > 100000.0 {
-		return c.JSON(http.StatusBadRequest, "Amount exceeds PersonalDeduction the allowed limit")
+	if request.Amount < 10000*Baht || request.Amount > 100000*Baht {
+		message := "Amount exceeds PersonalDeduction the allowed limit"
+		return validationFailed(c, message, FieldError{Pointer: "/amount", Message: message})
+	}
+
+	effectiveFrom, scheduled, err := scheduledAt(request.EffectiveFrom, h.settings.Now())
+	if err != nil {
+		message := fmt.Sprintf("Invalid value for effectiveFrom: %v", err)
+		return validationFailed(c, message, FieldError{Pointer: "/effectiveFrom", Message: message})
 	}
 
 	// Update the personal deduction value
-	PersonalDeduction = request.Amount
+	if _, err := h.settings.Update(c.Request().Context(), SettingPersonalDeduction, request.Amount, effectiveFrom, actorFromContext(c)); err != nil {
+		return settingUpdateFailed(c, "PersonalDeduction", err)
+	}
 
-	response := AdminPersonalDeductionResponse{PersonalDeduction: PersonalDeduction}
+	response := AdminPersonalDeductionResponse{PersonalDeduction: request.Amount, EffectiveFrom: scheduled}
 	return c.JSON(http.StatusOK, response)
 }
 
 // / TaxDetails handles the HTTP request for tax details.
-func TaxDetails(c echo.Context) error {
+func (h *Handler) TaxDetails(c echo.Context) error {
+	settings := h.settings.Current()
 	response := TaxDetailsResponse{
-		PersonalDeduction:      PersonalDeduction,
-		KreceiptLimitDeduction: KreceiptLimitDeduction,
+		PersonalDeduction:      settings.PersonalDeduction,
+		KreceiptLimitDeduction: settings.KreceiptLimitDeduction,
 	}
 	return c.JSON(http.StatusOK, response)
 }
 
 // Set KreceipLimitDeductionHandler handles the HTTP request for setting the K-receipt limit deduction by admin.
-func SetKreceipLimitDeductionHandler(c echo.Context) error {
+func (h *Handler) SetKreceipLimitDeductionHandler(c echo.Context) error {
 	var request AdminDeductionRequest
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid request")
+	if err := bind(c, &request); err != nil {
+		return bindFailed(c, err)
 	}
 
 	// Check if the requested amount is within the allowed range
-	if request.Amount < 10000.0 || request.Amount > 100000.0 {
-		return c.JSON(http.StatusBadRequest, "Amount exceeds KreceipLimitDeduction the allowed limit")
+	if request.Amount < 10000*Baht || request.Amount > 100000*Baht {
+		message := "Amount exceeds KreceipLimitDeduction the allowed limit"
+		return validationFailed(c, message, FieldError{Pointer: "/amount", Message: message})
+	}
+
+	effectiveFrom, scheduled, err := scheduledAt(request.EffectiveFrom, h.settings.Now())
+	if err != nil {
+		message := fmt.Sprintf("Invalid value for effectiveFrom: %v", err)
+		return validationFailed(c, message, FieldError{Pointer: "/effectiveFrom", Message: message})
 	}
 
 	// Update the Kreceipt limit deduction value
-	KreceiptLimitDeduction = request.Amount
+	if _, err := h.settings.Update(c.Request().Context(), SettingKreceiptLimit, request.Amount, effectiveFrom, actorFromContext(c)); err != nil {
+		return settingUpdateFailed(c, "KreceipLimitDeduction", err)
+	}
 
-	response := KreceiptLimitDeductionResponse{KreceiptLimitDeduction: KreceiptLimitDeduction}
+	response := KreceiptLimitDeductionResponse{KreceiptLimitDeduction: request.Amount, EffectiveFrom: scheduled}
 
 	return c.JSON(http.StatusOK, response)
 }
+
+// AuditLogHandler handles the HTTP request for listing admin deduction changes.
+// The optional query parameters setting, from and to filter the entries; from and to
+// accept RFC 3339 timestamps or YYYY-MM-DD dates, from is inclusive and to is exclusive.
+func (h *Handler) AuditLogHandler(c echo.Context) error {
+	filter := AuditFilter{Setting: c.QueryParam("setting")}
+	if filter.Setting != "" {
+		if _, err := DefaultSettings().With(filter.Setting, 0); err != nil {
+			return invalidParameter(c, "setting", fmt.Sprintf("Invalid value for setting: %v", err))
+		}
+	}
+
+	var err error
+	if filter.From, err = parseTime(c.QueryParam("from")); err != nil {
+		return invalidParameter(c, "from", fmt.Sprintf("Invalid value for from: %v", err))
+	}
+	if filter.To, err = parseTime(c.QueryParam("to")); err != nil {
+		return invalidParameter(c, "to", fmt.Sprintf("Invalid value for to: %v", err))
+	}
+
+	entries, err := h.settings.AuditLog(c.Request().Context(), filter)
+	if err != nil {
+		return internalError(c, fmt.Sprintf("Error reading audit log: %v", err))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"audit": entries})
+}
+
+// parseTime parses an RFC 3339 timestamp or a YYYY-MM-DD date in Thai time,
+// returning the zero time when value is empty.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, thaiTime)
+}
+
+// DeductionHistoryHandler handles the HTTP request for listing every scheduled and
+// past value of the admin deduction settings.
+func (h *Handler) DeductionHistoryHandler(c echo.Context) error {
+	history := h.settings.Snapshot()
+	if history == nil {
+		history = SettingsHistory{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"history": history})
+}
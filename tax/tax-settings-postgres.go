@@ -0,0 +1,65 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// PostgresSettingsRepository is a SettingsRepository stored in PostgreSQL. Changes
+// are stored with their audit entries in the table of PostgresAuditStore.
+type PostgresSettingsRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresSettingsRepository creates a PostgresSettingsRepository. The schema
+// must have been created with Migrate.
+func NewPostgresSettingsRepository(db *sql.DB) *PostgresSettingsRepository {
+	return &PostgresSettingsRepository{db: db}
+}
+
+// LoadHistory returns every stored change ordered by EffectiveFrom.
+func (r *PostgresSettingsRepository) LoadHistory(ctx context.Context) (SettingsHistory, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT name, amount_satang, effective_from
+		FROM deduction_setting_history
+		ORDER BY effective_from, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history SettingsHistory
+	for rows.Next() {
+		var change SettingChange
+		if err := rows.Scan(&change.Name, &change.Amount, &change.EffectiveFrom); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+// SaveChange stores a new change and its audit entry in one transaction.
+func (r *PostgresSettingsRepository) SaveChange(ctx context.Context, change SettingChange, entry AuditEntry) error {
+	if _, err := DefaultSettings().With(change.Name, change.Amount); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO deduction_setting_history (name, amount_satang, effective_from)
+		VALUES ($1, $2, $3)`,
+		change.Name, int64(change.Amount), change.EffectiveFrom)
+	if err != nil {
+		return err
+	}
+	if _, err := insertAudit(ctx, tx, entry); err != nil {
+		return fmt.Errorf("recording audit entry: %w", err)
+	}
+	return tx.Commit()
+}
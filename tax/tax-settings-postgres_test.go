@@ -0,0 +1,65 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestDatabase opens and migrates the database in TEST_DATABASE_URL,
+// skipping the test when it is not set.
+func openTestDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	// Running the migrations again must be a no-op
+	if err := Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPostgresSettingsRepository(t *testing.T) {
+	db := openTestDatabase(t)
+	ctx := context.Background()
+	repository := NewPostgresSettingsRepository(db)
+
+	effectiveFrom := time.Date(2090, 1, 1, 0, 0, 0, 0, time.UTC)
+	changedAt := time.Now().UTC().Truncate(time.Microsecond)
+	entry := AuditEntry{Setting: SettingPersonalDeduction, NewValue: 75000*Baht + 50*Satang, EffectiveFrom: effectiveFrom, ChangedAt: changedAt}
+	assert.NoError(t, repository.SaveChange(ctx, SettingChange{Name: SettingPersonalDeduction, Amount: 75000*Baht + 50*Satang, EffectiveFrom: effectiveFrom}, entry))
+	assert.Error(t, repository.SaveChange(ctx, SettingChange{Name: "unknown", Amount: 1000 * Baht, EffectiveFrom: effectiveFrom}, AuditEntry{Setting: "unknown", ChangedAt: changedAt}))
+
+	history, err := repository.LoadHistory(ctx)
+	assert.NoError(t, err)
+	settings := history.At(effectiveFrom, DefaultSettings())
+	assert.Equal(t, 75000*Baht+50*Satang, settings.PersonalDeduction)
+
+	// The change is recorded with its audit entry, the rejected one is not
+	entries, err := NewPostgresAuditStore(db).ListAudit(ctx, AuditFilter{From: changedAt})
+	assert.NoError(t, err)
+	settingNames := map[string]bool{}
+	for _, e := range entries {
+		settingNames[e.Setting] = true
+	}
+	assert.True(t, settingNames[SettingPersonalDeduction])
+	assert.False(t, settingNames["unknown"])
+}
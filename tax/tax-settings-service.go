@@ -0,0 +1,162 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPastEffectiveFrom is returned when an admin change is scheduled before now.
+var ErrPastEffectiveFrom = errors.New("effectiveFrom must not be in the past")
+
+// settingsReloadInterval is how often Run reloads the history, picking up the changes
+// made by admins on other instances
+const settingsReloadInterval = 30 * time.Second
+
+// thaiTime is the time zone of Thai tax years and of dates given without a time.
+var thaiTime = time.FixedZone("Asia/Bangkok", 7*60*60)
+
+// SettingsService publishes the history of the admin deduction settings as an immutable snapshot.
+//
+// Readers take one snapshot per calculation, or per CSV batch, so every row of a
+// calculation uses the same settings even while an admin is changing them.
+type SettingsService struct {
+	repository SettingsRepository
+	audit      AuditStore
+	now        func() time.Time
+
+	// mu serializes updates so the repository and the snapshot change in the same order
+	mu       sync.Mutex
+	snapshot atomic.Pointer[SettingsHistory]
+}
+
+// NewSettingsService creates a SettingsService with the history stored in repository,
+// which records every change in audit.
+func NewSettingsService(ctx context.Context, repository SettingsRepository, audit AuditStore) (*SettingsService, error) {
+	history, err := repository.LoadHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SettingsService{repository: repository, audit: audit, now: time.Now}
+	s.snapshot.Store(&history)
+	return s, nil
+}
+
+// Reload loads the history from the repository and publishes it as the new snapshot.
+func (s *SettingsService) Reload(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.repository.LoadHistory(ctx)
+	if err != nil {
+		return err
+	}
+	s.snapshot.Store(&history)
+	return nil
+}
+
+// Run reloads the history every settingsReloadInterval until ctx is done, so every
+// instance sharing the repository sees the changes of the others. Scheduled changes
+// are part of the history and apply from their effective date without a reload.
+func (s *SettingsService) Run(ctx context.Context) {
+	ticker := time.NewTicker(settingsReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error reloading deduction settings: %v", err)
+			}
+		}
+	}
+}
+
+// Snapshot returns the current history of setting changes.
+func (s *SettingsService) Snapshot() SettingsHistory {
+	return *s.snapshot.Load()
+}
+
+// Current returns the settings in effect now.
+func (s *SettingsService) Current() Settings {
+	return s.Snapshot().At(s.now(), DefaultSettings())
+}
+
+// Now returns the current time of the service clock.
+func (s *SettingsService) Now() time.Time {
+	return s.now()
+}
+
+// Update stores the amount of the named setting effective from effectiveFrom together
+// with its entry in the audit trail and publishes the new snapshot. A zero
+// effectiveFrom makes the change effective immediately; an effectiveFrom before now
+// is rejected with ErrPastEffectiveFrom, so changes never rewrite past calculations.
+//
+// The repository stores the change and the audit entry atomically, so a setting never
+// changes without a record and no record is left of a change that failed. The history
+// is loaded from the repository first, so the audit entry records the value in effect
+// even when it was changed on another instance.
+func (s *SettingsService) Update(ctx context.Context, name string, amount Money, effectiveFrom time.Time, actor Actor) (SettingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if effectiveFrom.IsZero() {
+		effectiveFrom = now
+	}
+	if effectiveFrom.Before(now) {
+		return SettingChange{}, ErrPastEffectiveFrom
+	}
+	change := SettingChange{Name: name, Amount: amount, EffectiveFrom: effectiveFrom.UTC()}
+
+	history, err := s.repository.LoadHistory(ctx)
+	if err != nil {
+		return SettingChange{}, err
+	}
+	before := history.At(change.EffectiveFrom, DefaultSettings())
+	if _, err := before.With(name, amount); err != nil {
+		return SettingChange{}, err
+	}
+
+	entry := AuditEntry{
+		Setting:       name,
+		OldValue:      before.Value(name),
+		NewValue:      amount,
+		EffectiveFrom: change.EffectiveFrom,
+		Username:      actor.Username,
+		RequestID:     actor.RequestID,
+		ChangedAt:     now.UTC(),
+	}
+	if err := s.repository.SaveChange(ctx, change, entry); err != nil {
+		return SettingChange{}, err
+	}
+
+	history = history.Add(change)
+	s.snapshot.Store(&history)
+	return change, nil
+}
+
+// AuditLog returns the recorded changes selected by the filter, oldest first.
+func (s *SettingsService) AuditLog(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	return s.audit.ListAudit(ctx, filter)
+}
+
+// effectiveDate returns the date whose settings apply to a calculation of a tax year:
+// now when it falls inside the tax year, otherwise the nearest end of the tax year.
+// Tax years are Buddhist Era calendar years in Thai time.
+func effectiveDate(taxYear int, now time.Time) time.Time {
+	start := time.Date(taxYear-543, time.January, 1, 0, 0, 0, 0, thaiTime)
+	end := start.AddDate(1, 0, 0).Add(-time.Nanosecond)
+	if now.Before(start) {
+		return start
+	}
+	if now.After(end) {
+		return end
+	}
+	return now
+}
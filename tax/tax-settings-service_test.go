@@ -0,0 +1,119 @@
+package tax
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsServiceUpdate(t *testing.T) {
+	ctx := context.Background()
+	audit := NewMemoryAuditStore()
+	repository := NewMemorySettingsRepository(audit)
+	service, err := NewSettingsService(ctx, repository, audit)
+	assert.NoError(t, err)
+
+	before := service.Snapshot()
+	change, err := service.Update(ctx, SettingPersonalDeduction, 70000*Baht, time.Time{}, Actor{})
+	assert.NoError(t, err)
+	assert.Equal(t, 70000*Baht, service.Current().PersonalDeduction)
+
+	// Snapshots taken before an update are not changed by it
+	assert.Empty(t, before)
+
+	// The update is stored in the repository
+	stored, err := repository.LoadHistory(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, SettingsHistory{change}, stored)
+
+	_, err = service.Update(ctx, "unknown", 1000*Baht, time.Time{}, Actor{})
+	assert.Error(t, err)
+}
+
+func TestSettingsServiceScheduledUpdate(t *testing.T) {
+	ctx := context.Background()
+	audit := NewMemoryAuditStore()
+	service, err := NewSettingsService(ctx, NewMemorySettingsRepository(audit), audit)
+	assert.NoError(t, err)
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, thaiTime)
+	service.now = func() time.Time { return now }
+
+	// A change scheduled for next year does not apply yet
+	nextYear := time.Date(2025, 1, 1, 0, 0, 0, 0, thaiTime)
+	_, err = service.Update(ctx, SettingKreceiptLimit, 80000*Baht, nextYear, Actor{Username: "adminTax"})
+	assert.NoError(t, err)
+	assert.Equal(t, 50000*Baht, service.Current().KreceiptLimitDeduction)
+
+	now = nextYear
+	assert.Equal(t, 80000*Baht, service.Current().KreceiptLimitDeduction)
+
+	// A change cannot be scheduled before now
+	_, err = service.Update(ctx, SettingKreceiptLimit, 90000*Baht, now.Add(-time.Second), Actor{Username: "adminTax"})
+	assert.ErrorIs(t, err, ErrPastEffectiveFrom)
+	assert.Equal(t, 80000*Baht, service.Current().KreceiptLimitDeduction)
+
+	entries, err := audit.ListAudit(ctx, AuditFilter{})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, nextYear.UTC(), entries[0].EffectiveFrom)
+		assert.Equal(t, 50000*Baht, entries[0].OldValue)
+	}
+}
+
+func TestSettingsServiceReload(t *testing.T) {
+	ctx := context.Background()
+	audit := NewMemoryAuditStore()
+	repository := NewMemorySettingsRepository(audit)
+	first, err := NewSettingsService(ctx, repository, audit)
+	assert.NoError(t, err)
+	second, err := NewSettingsService(ctx, repository, audit)
+	assert.NoError(t, err)
+
+	// A change made on another instance is seen after a reload
+	_, err = first.Update(ctx, SettingPersonalDeduction, 70000*Baht, time.Time{}, Actor{})
+	assert.NoError(t, err)
+	assert.Equal(t, 60000*Baht, second.Current().PersonalDeduction)
+	assert.NoError(t, second.Reload(ctx))
+	assert.Equal(t, 70000*Baht, second.Current().PersonalDeduction)
+
+	// Updates record the value stored by the other instance, not the stale snapshot
+	_, err = first.Update(ctx, SettingPersonalDeduction, 80000*Baht, time.Time{}, Actor{})
+	assert.NoError(t, err)
+	_, err = second.Update(ctx, SettingPersonalDeduction, 90000*Baht, time.Time{}, Actor{})
+	assert.NoError(t, err)
+	entries, err := audit.ListAudit(ctx, AuditFilter{})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, 80000*Baht, entries[2].OldValue)
+	}
+}
+
+func TestSettingsServiceConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	audit := NewMemoryAuditStore()
+	service, err := NewSettingsService(ctx, NewMemorySettingsRepository(audit), audit)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			amount := Money(10000+i*1000) * Baht
+			_, err := service.Update(ctx, SettingPersonalDeduction, amount, time.Time{}, Actor{})
+			assert.NoError(t, err)
+		}(i)
+		go func() {
+			defer wg.Done()
+			rules, err := ruleSet(DefaultTaxYear, service.Snapshot(), service.Now())
+			assert.NoError(t, err)
+			_, err = rules.Calculate(CalculationRequest{TotalIncome: 500000 * Baht})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
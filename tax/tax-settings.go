@@ -0,0 +1,149 @@
+package tax
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Names of the admin deduction settings.
+const (
+	SettingPersonalDeduction = "personal"
+	SettingKreceiptLimit     = "k-receipt"
+)
+
+// Settings represents the deduction settings an admin can change.
+type Settings struct {
+	PersonalDeduction      Money
+	KreceiptLimitDeduction Money
+}
+
+// DefaultSettings returns the deduction settings used before an admin changes them.
+func DefaultSettings() Settings {
+	return Settings{
+		PersonalDeduction:      60000 * Baht,
+		KreceiptLimitDeduction: 50000 * Baht,
+	}
+}
+
+// With returns a copy of the settings with the named setting changed.
+func (s Settings) With(name string, amount Money) (Settings, error) {
+	switch name {
+	case SettingPersonalDeduction:
+		s.PersonalDeduction = amount
+	case SettingKreceiptLimit:
+		s.KreceiptLimitDeduction = amount
+	default:
+		return s, fmt.Errorf("unknown setting %q", name)
+	}
+	return s, nil
+}
+
+// Value returns the amount of the named setting, or 0 for an unknown setting.
+func (s Settings) Value(name string) Money {
+	switch name {
+	case SettingPersonalDeduction:
+		return s.PersonalDeduction
+	case SettingKreceiptLimit:
+		return s.KreceiptLimitDeduction
+	}
+	return 0
+}
+
+// settingsOf returns the settings defined by a rule set.
+func settingsOf(rules RuleSet) Settings {
+	return Settings{
+		PersonalDeduction:      rules.PersonalDeduction,
+		KreceiptLimitDeduction: rules.KreceiptCap,
+	}
+}
+
+// Apply returns a copy of the rule set with the settings applied.
+func (s Settings) Apply(rules RuleSet) RuleSet {
+	rules.PersonalDeduction = s.PersonalDeduction
+	rules.KreceiptCap = s.KreceiptLimitDeduction
+	return rules
+}
+
+// SettingChange represents one value of a setting and the time it takes effect.
+type SettingChange struct {
+	Name          string    `json:"setting"`
+	Amount        Money     `json:"amount"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+}
+
+// SettingsHistory is the full history of setting changes ordered by EffectiveFrom.
+// Changes with the same EffectiveFrom keep the order they were made in.
+type SettingsHistory []SettingChange
+
+// Add returns a new history with the change inserted in order. The receiver is not modified.
+func (h SettingsHistory) Add(change SettingChange) SettingsHistory {
+	i := sort.Search(len(h), func(i int) bool { return h[i].EffectiveFrom.After(change.EffectiveFrom) })
+
+	history := make(SettingsHistory, 0, len(h)+1)
+	history = append(history, h[:i]...)
+	history = append(history, change)
+	return append(history, h[i:]...)
+}
+
+// At returns base with every setting replaced by its latest change effective at t.
+func (h SettingsHistory) At(t time.Time, base Settings) Settings {
+	for _, change := range h {
+		if change.EffectiveFrom.After(t) {
+			break
+		}
+		if updated, err := base.With(change.Name, change.Amount); err == nil {
+			base = updated
+		}
+	}
+	return base
+}
+
+// SettingsRepository stores the history of the admin deduction settings.
+type SettingsRepository interface {
+	// LoadHistory returns every stored change ordered by EffectiveFrom.
+	LoadHistory(ctx context.Context) (SettingsHistory, error)
+	// SaveChange stores a new change together with the audit entry recording it, so
+	// neither is stored without the other.
+	SaveChange(ctx context.Context, change SettingChange, entry AuditEntry) error
+}
+
+// MemorySettingsRepository is a SettingsRepository kept in memory, used in tests
+// and when no database is configured. Audit entries are appended to audit.
+type MemorySettingsRepository struct {
+	audit *MemoryAuditStore
+
+	mu      sync.Mutex
+	history SettingsHistory
+}
+
+// NewMemorySettingsRepository creates an empty MemorySettingsRepository recording
+// its changes in audit.
+func NewMemorySettingsRepository(audit *MemoryAuditStore) *MemorySettingsRepository {
+	return &MemorySettingsRepository{audit: audit}
+}
+
+// LoadHistory returns the changes held in memory.
+func (r *MemorySettingsRepository) LoadHistory(ctx context.Context) (SettingsHistory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.history, nil
+}
+
+// SaveChange stores a new change in memory and appends its audit entry under the
+// same lock, so a failed change leaves no entry.
+func (r *MemorySettingsRepository) SaveChange(ctx context.Context, change SettingChange, entry AuditEntry) error {
+	if _, err := DefaultSettings().With(change.Name, change.Amount); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.audit.AppendAudit(ctx, entry); err != nil {
+		return fmt.Errorf("recording audit entry: %w", err)
+	}
+	r.history = r.history.Add(change)
+	return nil
+}
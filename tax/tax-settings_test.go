@@ -0,0 +1,102 @@
+package tax
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsHistoryAt(t *testing.T) {
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, thaiTime)
+	jul := time.Date(2024, 7, 1, 0, 0, 0, 0, thaiTime)
+
+	var history SettingsHistory
+	history = history.Add(SettingChange{Name: SettingPersonalDeduction, Amount: 80000 * Baht, EffectiveFrom: jul})
+	history = history.Add(SettingChange{Name: SettingPersonalDeduction, Amount: 70000 * Baht, EffectiveFrom: jan})
+	history = history.Add(SettingChange{Name: SettingKreceiptLimit, Amount: 90000 * Baht, EffectiveFrom: jan})
+
+	// Changes are kept ordered by effective date
+	assert.Equal(t, jan, history[0].EffectiveFrom)
+	assert.Equal(t, jul, history[2].EffectiveFrom)
+
+	testCases := []struct {
+		name     string
+		at       time.Time
+		expected Settings
+	}{
+		{"before any change", jan.Add(-time.Hour), DefaultSettings()},
+		{"first change", jan, Settings{PersonalDeduction: 70000 * Baht, KreceiptLimitDeduction: 90000 * Baht}},
+		{"scheduled change", jul.Add(time.Hour), Settings{PersonalDeduction: 80000 * Baht, KreceiptLimitDeduction: 90000 * Baht}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, history.At(tc.at, DefaultSettings()))
+		})
+	}
+}
+
+func TestMemorySettingsRepository(t *testing.T) {
+	ctx := context.Background()
+	audit := NewMemoryAuditStore()
+	repository := NewMemorySettingsRepository(audit)
+	now := time.Now()
+
+	history, err := repository.LoadHistory(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+
+	assert.NoError(t, repository.SaveChange(ctx, SettingChange{Name: SettingPersonalDeduction, Amount: 70000 * Baht, EffectiveFrom: now}, AuditEntry{Setting: SettingPersonalDeduction}))
+	assert.NoError(t, repository.SaveChange(ctx, SettingChange{Name: SettingKreceiptLimit, Amount: 80000 * Baht, EffectiveFrom: now}, AuditEntry{Setting: SettingKreceiptLimit}))
+	assert.Error(t, repository.SaveChange(ctx, SettingChange{Name: "unknown", Amount: 1000 * Baht, EffectiveFrom: now}, AuditEntry{Setting: "unknown"}))
+
+	history, err = repository.LoadHistory(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Settings{PersonalDeduction: 70000 * Baht, KreceiptLimitDeduction: 80000 * Baht}, history.At(now, DefaultSettings()))
+
+	// Only the stored changes are recorded
+	entries, err := audit.ListAudit(ctx, AuditFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestRuleSetAppliesEffectiveSettings(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, thaiTime)
+	history := SettingsHistory{
+		{Name: SettingPersonalDeduction, Amount: 70000 * Baht, EffectiveFrom: time.Date(2024, 1, 1, 0, 0, 0, 0, thaiTime)},
+		{Name: SettingPersonalDeduction, Amount: 90000 * Baht, EffectiveFrom: time.Date(2025, 1, 1, 0, 0, 0, 0, thaiTime)},
+	}
+
+	// Without a tax year the settings in effect now are used
+	rules, err := ruleSet(0, history, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 70000*Baht, rules.PersonalDeduction)
+	assert.Equal(t, 50000*Baht, rules.KreceiptCap)
+
+	// DefaultTaxYear uses the settings in effect now, with or without the tax year,
+	// even after the year has ended
+	later := time.Date(2026, 6, 1, 0, 0, 0, 0, thaiTime)
+	omitted, err := ruleSet(0, history, later)
+	assert.NoError(t, err)
+	explicit, err := ruleSet(DefaultTaxYear, history, later)
+	assert.NoError(t, err)
+	assert.Equal(t, explicit, omitted)
+	assert.Equal(t, 90000*Baht, omitted.PersonalDeduction)
+
+	// A scheduled change applies to the tax year it takes effect in
+	restoreRuleSets(t)
+	assert.NoError(t, RegisterRuleSet(RuleSet{TaxYear: 2568, Brackets: DefaultBracketTable, PersonalDeduction: 60000 * Baht, DonationCap: 100000 * Baht, KreceiptCap: 50000 * Baht}))
+	rules, err = ruleSet(2568, history, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 90000*Baht, rules.PersonalDeduction)
+}
+
+func TestEffectiveDate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, thaiTime)
+
+	assert.Equal(t, now, effectiveDate(2567, now))
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, thaiTime).Add(-time.Nanosecond), effectiveDate(2566, now))
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, thaiTime), effectiveDate(2568, now))
+}
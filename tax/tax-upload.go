@@ -0,0 +1,435 @@
+package tax
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/xuri/excelize/v2"
+)
+
+// UploadLimits bounds the bulk calculation uploads.
+type UploadLimits struct {
+	MaxBytes int64 // MaxBytes is the maximum size of the request body
+	MaxRows  int   // MaxRows is the maximum number of data rows of the file
+}
+
+// DefaultUploadLimits returns the upload limits used when none are configured.
+func DefaultUploadLimits() UploadLimits {
+	return UploadLimits{
+		MaxBytes: 100 << 20,
+		MaxRows:  1000000,
+	}
+}
+
+// UploadLimitsFromEnv returns the upload limits set by MAX_UPLOAD_BYTES and MAX_UPLOAD_ROWS.
+func UploadLimitsFromEnv() (UploadLimits, error) {
+	limits := DefaultUploadLimits()
+	if value := os.Getenv("MAX_UPLOAD_BYTES"); value != "" {
+		maxBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			return limits, fmt.Errorf("invalid MAX_UPLOAD_BYTES %q", value)
+		}
+		limits.MaxBytes = maxBytes
+	}
+	if value := os.Getenv("MAX_UPLOAD_ROWS"); value != "" {
+		maxRows, err := strconv.Atoi(value)
+		if err != nil || maxRows <= 0 {
+			return limits, fmt.Errorf("invalid MAX_UPLOAD_ROWS %q", value)
+		}
+		limits.MaxRows = maxRows
+	}
+	return limits, nil
+}
+
+// UploadOptions are the parameters of a bulk calculation of an uploaded file.
+type UploadOptions struct {
+	Filename string `json:"filename"`
+	Sheet    string `json:"sheet,omitempty"`
+	TaxYear  int    `json:"taxYear,omitempty"`
+	OnError  string `json:"onError"`
+	Format   string `json:"format"`
+	Detail   bool   `json:"detail"`
+	// Encoding is the character encoding of a CSV file, detected when empty
+	Encoding string `json:"encoding,omitempty"`
+	// DecimalSeparator is the decimal separator of the amounts, detected when empty
+	DecimalSeparator string `json:"decimalSeparator,omitempty"`
+}
+
+// upload is an uploaded file with the parameters and rule set of its calculation
+type upload struct {
+	file    *multipart.FileHeader
+	options UploadOptions
+	rules   RuleSet
+}
+
+// readUpload limits the size of the request and reads its file, parameters and rule set.
+func (h *Handler) readUpload(c echo.Context) (upload, error) {
+	request := c.Request()
+	request.Body = http.MaxBytesReader(c.Response(), request.Body, h.limits.MaxBytes)
+
+	// Large files are spooled to disk by the multipart parser
+	file, err := c.FormFile("taxFile")
+	if err != nil {
+		return upload{}, err
+	}
+	options, err := parseUploadOptions(c, file.Filename)
+	if err != nil {
+		return upload{}, err
+	}
+	// One settings snapshot is used for every row of the file
+	rules, err := ruleSet(options.TaxYear, h.settings.Snapshot(), h.settings.Now())
+	if err != nil {
+		return upload{}, parameterError(err, "taxYear", fmt.Sprintf("Invalid value for taxYear: %v", err))
+	}
+	return upload{file: file, options: options, rules: rules}, nil
+}
+
+// open opens the uploaded file as a recordReader
+func (u upload) open() (recordReader, error) {
+	return openRecords(u.file, u.options.Sheet, u.options.Encoding)
+}
+
+// parseUploadOptions reads the upload parameters, returning a *ValidationError for invalid ones
+func parseUploadOptions(c echo.Context, filename string) (UploadOptions, error) {
+	options, err := parseReadOptions(c, filename)
+	if err != nil {
+		return options, err
+	}
+
+	// Select how invalid rows are handled
+	options.OnError = strings.TrimSpace(c.FormValue("onError"))
+	if options.OnError == "" {
+		options.OnError = CSVOnErrorAbort
+	}
+	if options.OnError != CSVOnErrorAbort && options.OnError != CSVOnErrorContinue {
+		message := fmt.Sprintf("Invalid value for onError: must be %q or %q", CSVOnErrorAbort, CSVOnErrorContinue)
+		return options, parameterError(nil, "onError", message)
+	}
+
+	// Select whether the results carry the full calculation
+	detail, err := parseDetail(c.QueryParam("detail"))
+	if err != nil {
+		return options, parameterError(err, "detail", fmt.Sprintf("Invalid value for detail: %v", err))
+	}
+	options.Detail = detail
+
+	// Select the format of the results
+	format, err := negotiateFormat(c.FormValue("format"), c.Request().Header.Get(echo.HeaderAccept))
+	if err != nil {
+		return options, parameterError(err, "format", fmt.Sprintf("Invalid value for format: %v", err))
+	}
+	options.Format = format
+
+	// Select the requested tax year
+	taxYear, err := parseTaxYear(c.FormValue("taxYear"))
+	if err != nil {
+		return options, parameterError(err, "taxYear", fmt.Sprintf("Invalid value for taxYear: %v", err))
+	}
+	options.TaxYear = taxYear
+
+	return options, nil
+}
+
+// parseReadOptions parses the sheet, encoding and decimalSeparator parameters
+func parseReadOptions(c echo.Context, filename string) (UploadOptions, error) {
+	options := UploadOptions{Filename: filename, Sheet: strings.TrimSpace(c.FormValue("sheet"))}
+
+	// Select the character encoding of CSV files
+	encoding, err := parseEncoding(c.FormValue("encoding"))
+	if err != nil {
+		return options, parameterError(err, "encoding", fmt.Sprintf("Invalid value for encoding: %v", err))
+	}
+	options.Encoding = encoding
+
+	// Select the decimal separator of the amounts
+	options.DecimalSeparator = strings.TrimSpace(c.FormValue("decimalSeparator"))
+	if options.DecimalSeparator != DecimalSeparatorAuto && options.DecimalSeparator != DecimalPoint && options.DecimalSeparator != DecimalComma {
+		message := fmt.Sprintf("Invalid value for decimalSeparator: must be %q or %q", DecimalPoint, DecimalComma)
+		return options, parameterError(nil, "decimalSeparator", message)
+	}
+	return options, nil
+}
+
+// tooManyRowsError is returned when a file has more data rows than allowed
+type tooManyRowsError struct {
+	max int
+}
+
+// Error returns the row limit
+func (e *tooManyRowsError) Error() string {
+	return fmt.Sprintf("file has more than %d rows", e.max)
+}
+
+// bulkCalculation is the calculation of every row of an uploaded file
+type bulkCalculation struct {
+	options UploadOptions
+	rules   RuleSet
+	maxRows int
+	workers int
+	// open returns a new reader of the file, which is read once by check and once by write
+	open func() (recordReader, error)
+}
+
+// check reads the whole file without calculating it and fails exactly when write would
+func (b bulkCalculation) check() error {
+	reader, err := b.open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	rows, err := newCSVRows(reader, b.rules, b.options.DecimalSeparator)
+	if err != nil {
+		return err
+	}
+
+	for count := 1; ; count++ {
+		row, record, err := rows.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if count > b.maxRows {
+			return &tooManyRowsError{max: b.maxRows}
+		}
+		if b.options.OnError != CSVOnErrorAbort {
+			continue
+		}
+		if _, rowErr := rows.layout.checkRecord(record, row, b.rules); rowErr != nil {
+			return rowErr
+		}
+	}
+}
+
+// write writes the result of every row to w, calling onRow, if not nil, before each row
+func (b bulkCalculation) write(w io.Writer, onRow func(resultRow) error) error {
+	reader, err := b.open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	rows, err := newCSVRows(reader, b.rules, b.options.DecimalSeparator)
+	if err != nil {
+		return err
+	}
+
+	withErrors := b.options.OnError == CSVOnErrorContinue
+	writer, err := newResultWriter(w, b.options.Format, rows.layout, b.rules, b.options.Detail, withErrors)
+	if err != nil {
+		return err
+	}
+	var count int
+	err = rows.Each(b.workers, func(row resultRow) error {
+		count++
+		if count > b.maxRows {
+			return &tooManyRowsError{max: b.maxRows}
+		}
+		if row.err != nil && !withErrors {
+			return row.err
+		}
+		if onRow != nil {
+			if err := onRow(row); err != nil {
+				return err
+			}
+		}
+		return writer.WriteRow(row)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// recordReader reads the records of a tax file one at a time, returning io.EOF at the end.
+type recordReader interface {
+	Read() ([]string, error)
+	Close() error
+}
+
+// openRecords opens an uploaded CSV or XLSX file as a recordReader
+func openRecords(file *multipart.FileHeader, sheet string, encoding string) (recordReader, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := newRecordReader(src, src, file.Filename, sheet, encoding)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// newRecordReader returns a recordReader of a CSV or XLSX file that closes closer when done
+func newRecordReader(src io.ReadSeeker, closer io.Closer, filename string, sheet string, encoding string) (recordReader, error) {
+	// Detect XLSX workbooks by extension or by their first bytes
+	head := make([]byte, len(xlsxMagic))
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if isXLSX(filename, head[:n]) {
+		reader, err := openXLSXRecords(src, sheet)
+		if err != nil {
+			return nil, err
+		}
+		return reader, closer.Close()
+	}
+
+	decoded, err := decodeCSV(src, encoding)
+	if err != nil {
+		return nil, err
+	}
+	// Rows with a wrong number of columns are reported per row
+	reader := csv.NewReader(decoded)
+	reader.FieldsPerRecord = -1
+	return csvRecordReader{Reader: reader, Closer: closer}, nil
+}
+
+// csvRecordReader is a recordReader of a CSV file
+type csvRecordReader struct {
+	*csv.Reader
+	io.Closer
+}
+
+// xlsxRecordReader is a recordReader of one sheet of an XLSX workbook. Empty rows are
+// skipped and short rows are padded to the width of the first row.
+type xlsxRecordReader struct {
+	file  *excelize.File
+	rows  *excelize.Rows
+	width int
+}
+
+// openXLSXRecords opens a sheet of an XLSX workbook as a recordReader. Cells are read
+// as raw values, so amounts are not affected by the number format of the workbook.
+// Rows are read one at a time, but the zipped workbook is held in memory.
+func openXLSXRecords(r io.Reader, sheet string) (*xlsxRecordReader, error) {
+	f, err := excelize.OpenReader(r, excelize.Options{RawCellValue: true, UnzipSizeLimit: maxXLSXUnzipSize})
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX file: %w", err)
+	}
+
+	if sheet == "" {
+		sheet = f.GetSheetName(0)
+	} else if index, err := f.GetSheetIndex(sheet); err != nil || index < 0 {
+		f.Close()
+		return nil, fmt.Errorf("%w: %q", ErrSheetNotFound, sheet)
+	}
+
+	rows, err := f.Rows(sheet)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &xlsxRecordReader{file: f, rows: rows}, nil
+}
+
+// Read returns the next non-empty row of the sheet.
+func (r *xlsxRecordReader) Read() ([]string, error) {
+	for r.rows.Next() {
+		row, err := r.rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		if isEmptyRow(row) {
+			continue
+		}
+		if r.width == 0 {
+			r.width = len(row)
+		} else if len(row) < r.width {
+			row = append(row, make([]string, r.width-len(row))...)
+		}
+		return row, nil
+	}
+	if err := r.rows.Error(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Close closes the sheet and the workbook.
+func (r *xlsxRecordReader) Close() error {
+	r.rows.Close()
+	return r.file.Close()
+}
+
+// csvRows calculates the data rows of a recordReader one at a time
+type csvRows struct {
+	reader  recordReader
+	rules   RuleSet
+	layout  csvLayout
+	row     int      // row is the number of records read
+	pending []string // pending holds the first record of a file without header row
+}
+
+// newCSVRows reads the header row, returning a *CSVRowError when it is invalid
+func newCSVRows(reader recordReader, rules RuleSet, decimal string) (*csvRows, error) {
+	rows := &csvRows{reader: reader, rules: rules, layout: csvLayout{columns: legacyCSVColumns, decimal: decimal}}
+
+	first, err := reader.Read()
+	if err == io.EOF {
+		return rows, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Files saved by Excel start with a byte order mark, which is not part of the first cell
+	if len(first) > 0 && strings.HasPrefix(first[0], "\ufeff") {
+		first = append([]string{strings.TrimPrefix(first[0], "\ufeff")}, first[1:]...)
+	}
+	rows.layout, err = parseCSVHeader(first, decimal)
+	if err != nil {
+		return nil, err
+	}
+	if rows.layout.first == 0 {
+		rows.pending = first
+	} else {
+		rows.row = 1
+	}
+	return rows, nil
+}
+
+// Next calculates the next data row, returning io.EOF after the last row.
+func (r *csvRows) Next() (resultRow, error) {
+	row, record, err := r.read()
+	if err != nil {
+		return resultRow{}, err
+	}
+	return r.calculate(row, record), nil
+}
+
+// read returns the next data record and its row number, or io.EOF after the last record
+func (r *csvRows) read() (int, []string, error) {
+	record := r.pending
+	r.pending = nil
+	if record == nil {
+		var err error
+		record, err = r.reader.Read()
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	r.row++
+	return r.row, record, nil
+}
+
+// calculate calculates one data record; it is safe for concurrent use
+func (r *csvRows) calculate(row int, record []string) resultRow {
+	result, rowErr := r.layout.calculateRecord(record, row, r.rules)
+	if rowErr != nil {
+		return resultRow{row: row, record: record, err: rowErr}
+	}
+	return resultRow{row: row, record: record, result: &result}
+}
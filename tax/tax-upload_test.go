@@ -0,0 +1,160 @@
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadLimitsFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes string
+		maxRows  string
+		expected UploadLimits
+		wantErr  bool
+	}{
+		{name: "defaults", expected: DefaultUploadLimits()},
+		{name: "configured", maxBytes: "1048576", maxRows: "10", expected: UploadLimits{MaxBytes: 1 << 20, MaxRows: 10}},
+		{name: "invalid bytes", maxBytes: "1MB", wantErr: true},
+		{name: "invalid rows", maxRows: "0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAX_UPLOAD_BYTES", tt.maxBytes)
+			t.Setenv("MAX_UPLOAD_ROWS", tt.maxRows)
+
+			limits, err := UploadLimitsFromEnv()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, limits)
+		})
+	}
+}
+
+// sliceRecordReader is a recordReader of records already in memory
+type sliceRecordReader struct {
+	records [][]string
+}
+
+// Read returns the next record.
+func (r *sliceRecordReader) Read() ([]string, error) {
+	if len(r.records) == 0 {
+		return nil, io.EOF
+	}
+	record := r.records[0]
+	r.records = r.records[1:]
+	return record, nil
+}
+
+// Close does nothing.
+func (r *sliceRecordReader) Close() error {
+	return nil
+}
+
+func TestCSVRows(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		records [][]string
+		rows    []int
+	}{
+		{name: "header", records: [][]string{{"totalIncome"}, {"500000"}, {"x"}}, rows: []int{2, 3}},
+		{name: "no header", records: [][]string{{"500000", "0", "0"}, {"600000", "0", "0"}}, rows: []int{1, 2}},
+		{name: "empty", records: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := newCSVRows(&sliceRecordReader{records: tt.records}, rules, DecimalSeparatorAuto)
+			assert.NoError(t, err)
+
+			var numbers []int
+			for {
+				row, err := rows.Next()
+				if err == io.EOF {
+					break
+				}
+				assert.NoError(t, err)
+				numbers = append(numbers, row.row)
+			}
+			assert.Equal(t, tt.rows, numbers)
+		})
+	}
+}
+
+func TestCalculateTaxFromCSVHandlerUploadLimits(t *testing.T) {
+	csvContent := "totalIncome\n500000\n600000\n700000\n"
+
+	tests := []struct {
+		name   string
+		limits UploadLimits
+		code   int
+	}{
+		{name: "within limits", limits: UploadLimits{MaxBytes: 1 << 20, MaxRows: 3}, code: http.StatusOK},
+		{name: "too many rows", limits: UploadLimits{MaxBytes: 1 << 20, MaxRows: 2}, code: http.StatusRequestEntityTooLarge},
+		{name: "too many bytes", limits: UploadLimits{MaxBytes: 64, MaxRows: 3}, code: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte(csvContent), nil)
+			rec := httptest.NewRecorder()
+
+			h := newTestHandler(t)
+			h.SetUploadLimits(tt.limits)
+			err := h.CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.code != http.StatusOK {
+				assert.Contains(t, rec.Body.String(), `"code":"file_too_large"`)
+			}
+		})
+	}
+}
+
+func TestCalculateTaxFromCSVHandlerLargeFile(t *testing.T) {
+	const rows = 20000
+
+	var content strings.Builder
+	content.WriteString("totalIncome,wht\n")
+	for i := 0; i < rows; i++ {
+		if i%1000 == 999 {
+			content.WriteString("invalid,0\n")
+			continue
+		}
+		fmt.Fprintf(&content, "%d,0\n", 500000+i)
+	}
+
+	e := echo.New()
+	req := newUploadRequest(t, "/tax/calculations/upload-csv", "taxes.csv", []byte(content.String()), map[string]string{"onError": "continue"})
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).CalculateTaxFromCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response struct {
+		Taxes  []CSVRowResult `json:"taxes"`
+		Errors []CSVRowError  `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Len(t, response.Taxes, rows-rows/1000)
+	assert.Len(t, response.Errors, rows/1000)
+	assert.Equal(t, 2, response.Taxes[0].Row)
+	assert.Equal(t, 1001, response.Errors[0].Row)
+}
@@ -0,0 +1,152 @@
+package tax
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CSVRowWarning reports a valid data row to review, such as a duplicate of an earlier row.
+type CSVRowWarning struct {
+	Row         int    `json:"row"`
+	DuplicateOf int    `json:"duplicateOf,omitempty"`
+	Reason      string `json:"reason"`
+}
+
+// CSVValidationReport is the result of checking an uploaded file without calculating it.
+type CSVValidationReport struct {
+	Valid     bool            `json:"valid"`
+	Columns   []string        `json:"columns"`
+	Rows      int             `json:"rows"`
+	ValidRows int             `json:"validRows"`
+	Errors    []CSVRowError   `json:"errors"`
+	Warnings  []CSVRowWarning `json:"warnings"`
+}
+
+// maxDuplicateRows is the number of rows kept in memory to find the duplicates of later rows
+const maxDuplicateRows = 10000
+
+// taxDataKey returns a hash of the amounts of tax data, equal for rows with the same amounts
+func taxDataKey(data TaxData) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	write := func(amount Money) {
+		binary.LittleEndian.PutUint64(buf[:], uint64(amount))
+		h.Write(buf[:])
+	}
+	write(data.TotalIncome)
+	write(data.WHT)
+	for _, allowance := range data.Allowances {
+		h.Write([]byte(allowance.AllowanceType))
+		write(allowance.Amount)
+	}
+	return h.Sum64()
+}
+
+// sameTaxData reports whether two rows have the same amounts
+func sameTaxData(a, b TaxData) bool {
+	if a.TotalIncome != b.TotalIncome || a.WHT != b.WHT || len(a.Allowances) != len(b.Allowances) {
+		return false
+	}
+	for i := range a.Allowances {
+		if a.Allowances[i].AllowanceType != b.Allowances[i].AllowanceType || a.Allowances[i].Amount != b.Allowances[i].Amount {
+			return false
+		}
+	}
+	return true
+}
+
+// seenRow is a valid row kept to confirm the duplicates found by taxDataKey
+type seenRow struct {
+	row  int
+	data TaxData
+}
+
+// findDuplicate returns the first of the rows with the same amounts as data
+func findDuplicate(rows []seenRow, data TaxData) (int, bool) {
+	for _, r := range rows {
+		if sameTaxData(r.data, data) {
+			return r.row, true
+		}
+	}
+	return 0, false
+}
+
+// validateCSV checks every row of a file with rules, reporting an invalid header row
+func validateCSV(reader recordReader, rules RuleSet, decimal string, maxRows int) (CSVValidationReport, error) {
+	report := CSVValidationReport{Errors: []CSVRowError{}, Warnings: []CSVRowWarning{}}
+
+	rows, err := newCSVRows(reader, rules, decimal)
+	var rowErr *CSVRowError
+	if errors.As(err, &rowErr) {
+		report.Errors = append(report.Errors, *rowErr)
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	report.Columns = rows.layout.columns
+
+	// Rows are looked up by a hash of their amounts and compared with the rows of the
+	// same hash, so a hash collision is not reported as a duplicate
+	seen := map[uint64][]seenRow{}
+	var kept int
+	for {
+		row, record, err := rows.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Rows++
+		if report.Rows > maxRows {
+			return report, &tooManyRowsError{max: maxRows}
+		}
+
+		data, rowErr := rows.layout.checkRecord(record, row, rows.rules)
+		if rowErr != nil {
+			report.Errors = append(report.Errors, *rowErr)
+			continue
+		}
+		report.ValidRows++
+
+		key := taxDataKey(data)
+		if first, ok := findDuplicate(seen[key], data); ok {
+			reason := fmt.Sprintf("same amounts as row %d", first)
+			report.Warnings = append(report.Warnings, CSVRowWarning{Row: row, DuplicateOf: first, Reason: reason})
+			continue
+		}
+		if kept < maxDuplicateRows {
+			seen[key] = append(seen[key], seenRow{row: row, data: data})
+			kept++
+		}
+	}
+
+	report.Valid = len(report.Errors) == 0
+	return report, nil
+}
+
+// ValidateCSVHandler handles POST /tax/calculations/validate-csv and checks an upload without calculating it.
+func (h *Handler) ValidateCSVHandler(c echo.Context) error {
+	upload, err := h.readUpload(c)
+	if err != nil {
+		return uploadFailed(c, err)
+	}
+	reader, err := upload.open()
+	if err != nil {
+		return uploadFailed(c, err)
+	}
+	defer reader.Close()
+
+	report, err := validateCSV(reader, upload.rules, upload.options.DecimalSeparator, h.limits.MaxRows)
+	if err != nil {
+		return uploadFailed(c, err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
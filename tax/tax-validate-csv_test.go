@@ -0,0 +1,196 @@
+package tax
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  [][]string
+		expected CSVValidationReport
+	}{
+		{
+			name:    "valid",
+			records: [][]string{{"totalIncome", "wht", "donation"}, {"500000", "0", "0"}, {"600000", "40000", "20000"}},
+			expected: CSVValidationReport{
+				Valid: true, Columns: []string{"totalIncome", "wht", "donation"}, Rows: 2, ValidRows: 2,
+				Errors: []CSVRowError{}, Warnings: []CSVRowWarning{},
+			},
+		},
+		{
+			name: "invalid rows",
+			records: [][]string{
+				{"totalIncome", "wht", "donation"},
+				{"-500000", "0", "0"},
+				{"500000", "-1", "0"},
+				{"500000", "0", "-1"},
+				{"500000", "600000", "0"},
+				{"abc", "0", "0"},
+				{"500000", "0"},
+			},
+			expected: CSVValidationReport{
+				Columns: []string{"totalIncome", "wht", "donation"}, Rows: 6,
+				Errors: []CSVRowError{
+					{Row: 2, Column: "totalIncome", Value: "-500000", Reason: "totalIncome must not be negative"},
+					{Row: 3, Column: "wht", Value: "-1", Reason: "wht must not be negative"},
+					{Row: 4, Column: "donation", Value: "-1", Reason: "invalid allowance amount: donation must not be negative"},
+					{Row: 5, Column: "wht", Value: "600000", Reason: "withholding tax cannot be greater than the total income"},
+					{Row: 6, Column: "totalIncome", Value: "abc", Reason: "invalid totalIncome"},
+					{Row: 7, Reason: "invalid CSV format: expected 3 columns, got 2"},
+				},
+				Warnings: []CSVRowWarning{},
+			},
+		},
+		{
+			name: "duplicate rows",
+			records: [][]string{
+				{"totalIncome", "wht", "donation"},
+				{"500000", "0", "0"},
+				{"600000", "0", "0"},
+				{"500,000.00", "", "0"},
+				{"500000", "0", "0"},
+			},
+			expected: CSVValidationReport{
+				Valid: true, Columns: []string{"totalIncome", "wht", "donation"}, Rows: 4, ValidRows: 4,
+				Errors: []CSVRowError{},
+				Warnings: []CSVRowWarning{
+					{Row: 4, DuplicateOf: 2, Reason: "same amounts as row 2"},
+					{Row: 5, DuplicateOf: 2, Reason: "same amounts as row 2"},
+				},
+			},
+		},
+		{
+			name:    "unknown column",
+			records: [][]string{{"totalIncome", "bonus"}, {"500000", "0"}},
+			expected: CSVValidationReport{
+				Errors:   []CSVRowError{{Row: 1, Column: "bonus", Reason: "invalid CSV format: unknown column"}},
+				Warnings: []CSVRowWarning{},
+			},
+		},
+	}
+
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := validateCSV(&sliceRecordReader{records: tt.records}, rules, DecimalSeparatorAuto, 100)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, report)
+		})
+	}
+}
+
+func TestValidateCSVHandler(t *testing.T) {
+	e := echo.New()
+	csvContent := "totalIncome,wht,donation\n500000,0,0\n500000,600000,0\n"
+	req := newUploadRequest(t, "/tax/calculations/validate-csv", "taxes.csv", []byte(csvContent), nil)
+	rec := httptest.NewRecorder()
+
+	err := newTestHandler(t).ValidateCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"valid": false,
+		"columns": ["totalIncome", "wht", "donation"],
+		"rows": 2,
+		"validRows": 1,
+		"errors": [{"row": 3, "column": "wht", "value": "600000", "reason": "withholding tax cannot be greater than the total income"}],
+		"warnings": []
+	}`, rec.Body.String())
+}
+
+func TestValidateCSVHandlerTooManyRows(t *testing.T) {
+	e := echo.New()
+	req := newUploadRequest(t, "/tax/calculations/validate-csv", "taxes.csv", []byte("totalIncome\n500000\n600000\n"), nil)
+	rec := httptest.NewRecorder()
+
+	h := newTestHandler(t)
+	h.SetUploadLimits(UploadLimits{MaxBytes: 1 << 20, MaxRows: 1})
+	err := h.ValidateCSVHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	var response ErrorResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, ErrorCodeFileTooLarge, response.Code)
+}
+
+func TestValidateCSVKeepsBoundedRows(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	assert.NoError(t, err)
+	records := [][]string{{"totalIncome"}}
+	for i := 0; i < maxDuplicateRows+1; i++ {
+		records = append(records, []string{strconv.Itoa(100000 + i)})
+	}
+	// Duplicates of the first maxDuplicateRows rows are found, later rows are not kept
+	records = append(records, []string{"100000"}, []string{strconv.Itoa(100000 + maxDuplicateRows)})
+
+	report, err := validateCSV(&sliceRecordReader{records: records}, rules, DecimalSeparatorAuto, len(records))
+	assert.NoError(t, err)
+	assert.True(t, report.Valid)
+	assert.Equal(t, []CSVRowWarning{{Row: len(records) - 1, DuplicateOf: 2, Reason: "same amounts as row 2"}}, report.Warnings)
+}
+
+func TestValidateCSVHandlerUploadParameters(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		fields    map[string]string
+		status    int
+		parameter string
+	}{
+		{name: "accepted", target: "/tax/calculations/validate-csv?detail=true", fields: map[string]string{"format": "csv", "onError": "continue", "taxYear": "2567"}, status: http.StatusOK},
+		{name: "invalid format", target: "/tax/calculations/validate-csv", fields: map[string]string{"format": "pdf"}, status: http.StatusBadRequest, parameter: "format"},
+		{name: "invalid onError", target: "/tax/calculations/validate-csv", fields: map[string]string{"onError": "skip"}, status: http.StatusBadRequest, parameter: "onError"},
+		{name: "invalid detail", target: "/tax/calculations/validate-csv?detail=full", status: http.StatusBadRequest, parameter: "detail"},
+		{name: "unknown taxYear", target: "/tax/calculations/validate-csv", fields: map[string]string{"taxYear": "1999"}, status: http.StatusBadRequest, parameter: "taxYear"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := newUploadRequest(t, tt.target, "taxes.csv", []byte("totalIncome\n500000\n"), tt.fields)
+			rec := httptest.NewRecorder()
+
+			err := newTestHandler(t).ValidateCSVHandler(e.NewContext(req, rec))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.parameter == "" {
+				assert.Contains(t, rec.Body.String(), `"valid":true`)
+				return
+			}
+
+			var response ErrorResponse
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+			if assert.Len(t, response.Fields, 1) {
+				assert.Equal(t, tt.parameter, response.Fields[0].Parameter)
+			}
+		})
+	}
+}
+
+func TestFindDuplicate(t *testing.T) {
+	data := TaxData{TotalIncome: 500000 * Baht, Allowances: []Allowance{{AllowanceType: "donation", Amount: 1000 * Baht}}}
+	seen := []seenRow{
+		{row: 2, data: TaxData{TotalIncome: 600000 * Baht}},
+		{row: 3, data: TaxData{TotalIncome: 500000 * Baht, Allowances: []Allowance{{AllowanceType: "k-receipt", Amount: 1000 * Baht}}}},
+		{row: 4, data: data},
+	}
+
+	// Rows under the same hash with other amounts are not duplicates
+	first, ok := findDuplicate(seen, data)
+	assert.True(t, ok)
+	assert.Equal(t, 4, first)
+
+	_, ok = findDuplicate(seen[:2], data)
+	assert.False(t, ok)
+}
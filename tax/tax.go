@@ -1,83 +1,176 @@
 package tax
 
 import (
-	"errors"
+	"fmt"
 	_ "net/http"
 
 	_ "github.com/labstack/echo/v4"
 )
 
-// Allowance represents a type of allowance with its amount.
+// Allowance represents a type of allowance with its amount. DonationType sets the
+// category of a donation; education, sports and hospital donations are deducted at
+// twice their amount.
 type Allowance struct {
-	AllowanceType string  `json:"allowanceType"`
-	Amount        float64 `json:"amount"`
+	AllowanceType string `json:"allowanceType"`
+	Amount        Money  `json:"amount"`
+	DonationType  string `json:"donationType,omitempty"`
 }
 
 // CalculationRequest represents the request structure for tax calculation.
+// Incomes optionally split the total income by category so the standard expense
+// deduction of every category is deducted; totalIncome may then be omitted. Profile
+// optionally describes the family of the taxpayer for the family allowances.
 type CalculationRequest struct {
-	TotalIncome float64     `json:"totalIncome"`
-	WHT         float64     `json:"wht"`
-	Allowances  []Allowance `json:"allowances"`
+	TotalIncome Money            `json:"totalIncome"`
+	Incomes     []Income         `json:"incomes,omitempty"`
+	WHT         Money            `json:"wht"`
+	Allowances  []Allowance      `json:"allowances"`
+	Profile     *TaxpayerProfile `json:"profile,omitempty"`
+	TaxYear     int              `json:"taxYear"`
 }
 
 // TaxLevel represents the tax level structure for tax calculation.
 type TaxLevel struct {
-	Level string  `json:"level"`
-	Tax   float64 `json:"tax"`
+	Level string `json:"level"`
+	Tax   Money  `json:"tax"`
+}
+
+// Deduction represents the amount deducted from the income for one deduction type.
+type Deduction struct {
+	Type   string `json:"type"`
+	Amount Money  `json:"amount"`
 }
 
 // CalculationResponse represents the response structure for tax calculation.
+// For a request with incomes it also shows the gross income, the expense deduction
+// and the income net of expenses, in total and per category, and, when income other
+// than salary is subject to the minimum tax, the tax of both methods. For a request with
+// donations it explains the donation cap. Allowances reports how much of each requested
+// allowance type was allowed.
 type CalculationResponse struct {
-	Tax       float64    `json:"tax"`
-	TaxRefund float64    `json:"taxRefund"`
-	TaxLevel  []TaxLevel `json:"taxLevel"`
+	Tax              Money              `json:"tax"`
+	TaxRefund        Money              `json:"taxRefund"`
+	TaxLevel         []TaxLevel         `json:"taxLevel"`
+	TaxYear          int                `json:"taxYear"`
+	GrossIncome      Money              `json:"grossIncome,omitempty"`
+	ExpenseDeduction Money              `json:"expenseDeduction,omitempty"`
+	NetIncome        Money              `json:"netIncome,omitempty"`
+	Incomes          []IncomeSummary    `json:"incomes,omitempty"`
+	TaxMethod        *TaxMethod         `json:"taxMethod,omitempty"`
+	TaxableIncome    Money              `json:"taxableIncome"`
+	Deductions       []Deduction        `json:"deductions"`
+	Allowances       []AllowanceSummary `json:"allowances,omitempty"`
+	Donation         *DonationDeduction `json:"donation,omitempty"`
 }
 
-// calculateTax calculates the tax based on income and allowances.
-func CalculateTax(income float64, wht float64, allowances []Allowance, personalDeduction float64) (CalculationResponse, error) {
-	var tax float64
-	var taxFinalPaid float64
-	var donationDeduction float64
-	var kreceiptDeduction float64
-	// Define tax levels
-	taxLevels := []TaxLevel{
-		{"0-150,000", 0.0},
-		{"150,001-500,000", 0.0},
-		{"500,001-1,000,000", 0.0},
-		{"1,000,001-2,000,000", 0.0},
-		{"2,000,001 ขึ้นไป", 0.0},
+// Check checks a request as Calculate does, without calculating its tax. It returns a
+// *ValidationError for the first invalid field.
+func (rs RuleSet) Check(request CalculationRequest) error {
+	gross := request.grossIncome()
+	if len(request.Incomes) > 0 {
+		// Keep the sum of the incomes within MaxMoney so the rates applied to it cannot overflow
+		if gross > MaxMoney {
+			return fieldError(nil, "/incomes", fmt.Sprintf("the sum of the incomes must not exceed %s", MaxMoney))
+		}
+		for n, income := range request.Incomes {
+			if err := validateIncome(n, income); err != nil {
+				return err
+			}
+		}
+		if request.TotalIncome != 0 && request.TotalIncome != gross {
+			return fieldError(nil, "/totalIncome", "total income must equal the sum of the incomes")
+		}
 	}
 
-	// personalAllowance represents the fixed personal allowance.
-	if personalDeduction < 10000 { // Ensure that personal deductio is not less 10000
-		personalDeduction = 10000
-	}
-
-	// Calculate donation deduction
-	for _, allowance := range allowances {
-		if allowance.AllowanceType == "donation" {
-			if allowance.Amount > 100000 { // Ensure that donation allowance limit is 100000
-				donationDeduction = 100000
-			} else if allowance.Amount < 0 { // Ensure that donation allowance is not negative
-				donationDeduction = 0
-			} else {
-				donationDeduction = allowance.Amount
-			}
+	if request.Profile != nil {
+		if err := request.Profile.Validate(rs.TaxYear); err != nil {
+			return err
 		}
+	}
+	for n, allowance := range request.Allowances {
+		if _, _, err := validateAllowance(n, allowance); err != nil {
+			return err
+		}
+	}
 
-		if allowance.AllowanceType == "k-receipt" {
-			if allowance.Amount > KreceiptLimitDeduction { // Ensure that kreceipt allowance limit is 100000
-				kreceiptDeduction = KreceiptLimitDeduction
-			} else if allowance.Amount < 0 { // Ensure that kreceipt allowance is not negative
-				kreceiptDeduction = 0
-			} else {
-				kreceiptDeduction = allowance.Amount
-			}
+	// Ensure that the withholding tax provided for the calculation does not exceed the total income.
+	if request.WHT > gross {
+		return fieldError(nil, "/wht", "withholding tax cannot be greater than the total income")
+	}
+	return nil
+}
+
+// Calculate calculates the tax of a request using the rules of the rule set. The request
+// is checked with Check first.
+//
+// All arithmetic is exact in satang. The tax of each band is rounded half away
+// from zero to the satang, the total tax is the sum of the rounded bands and the
+// final tax or refund is the total tax minus WHT without further rounding.
+func (rs RuleSet) Calculate(request CalculationRequest) (CalculationResponse, error) {
+	if err := rs.Check(request); err != nil {
+		return CalculationResponse{}, err
+	}
+
+	var taxFinalPaid Money
+	income := request.TotalIncome
+	wht := request.WHT
+
+	// Deduct the standard expenses of every income category
+	var incomes []IncomeSummary
+	netIncome := income
+	if len(request.Incomes) > 0 {
+		var err error
+		incomes, err = applyIncomes(request.Incomes)
+		if err != nil {
+			return CalculationResponse{}, err
+		}
+		income = request.grossIncome()
+		netIncome = 0
+		for _, summary := range incomes {
+			netIncome += summary.NetIncome
 		}
 	}
 
+	// personalAllowance represents the fixed personal allowance.
+	personalDeduction := rs.PersonalDeduction
+	if personalDeduction < 10000*Baht { // Ensure that personal deductio is not less 10000
+		personalDeduction = 10000 * Baht
+	}
+
+	deductions := []Deduction{{Type: SettingPersonalDeduction, Amount: personalDeduction}}
+
+	// Derive the family allowances from the taxpayer profile
+	var familyDeduction Money
+	if request.Profile != nil {
+		for _, d := range request.Profile.Deductions() {
+			familyDeduction += d.Amount
+			deductions = append(deductions, d)
+		}
+	}
+
+	// Calculate allowance deductions with the registered allowance rules, donations last
+	// on the income after every other deduction
+	ctx := AllowanceContext{
+		Rules:        rs,
+		Income:       netIncome,
+		GrossIncome:  income,
+		SalaryIncome: request.salaryIncome(),
+		Deducted:     personalDeduction + familyDeduction,
+	}
+	applied, err := applyAllowances(request.Allowances, ctx)
+	if err != nil {
+		return CalculationResponse{}, err
+	}
+	var allowanceDeduction Money
+	var allowances []AllowanceSummary
+	for _, a := range applied {
+		allowanceDeduction += a.Deducted
+		deductions = append(deductions, Deduction{Type: a.Type, Amount: a.Deducted})
+		allowances = append(allowances, AllowanceSummary{AllowanceType: a.Type, Group: a.Group, Requested: a.Requested, Allowed: a.Deducted})
+	}
+
 	// Calculate taxable income after deductions
-	incomeAfterDeductions := income - personalDeduction - donationDeduction - kreceiptDeduction
+	incomeAfterDeductions := netIncome - personalDeduction - familyDeduction - allowanceDeduction
 
 	// Ensure that income after deductions is not negative
 	if incomeAfterDeductions < 0 {
@@ -86,76 +179,53 @@ func CalculateTax(income float64, wht float64, allowances []Allowance, personalD
 	taxableIncome := incomeAfterDeductions
 
 	// Calculate tax for each level
-	for i := range taxLevels {
-		tax = 0
-		switch i {
-		case 0: // "0-150,000"
-			if taxableIncome < 150000 {
-				tax = 0
-			}
-		case 1: // "150,001-500,000"
-			if taxableIncome > 150000 {
-				if taxableIncome > 500000 {
-					tax = 35000
-				} else {
-					tax = (taxableIncome - 150000) * 0.10
-				}
-			}
-		case 2: // "500,001-1,000,000"
-			if taxableIncome > 500000 {
-				if taxableIncome > 1000000 {
-					tax = 95000
-				} else {
-					tax = (taxableIncome - 500000) * 0.15
-				}
-			}
-		case 3: // "1,000,001-2,000,000"
-			if taxableIncome > 1000000 {
-				if taxableIncome > 2000000 {
-					tax = 335000
-				} else {
-					tax = (taxableIncome - 1000000) * 0.20
-				}
-			}
-		case 4: // "2,000,001 more"
-			if taxableIncome > 2000000 {
-				tax = (taxableIncome - 2000000) * 0.35
-			}
-		}
-		taxLevels[i].Tax = tax
-	}
+	taxLevels := rs.Brackets.Levels(taxableIncome)
 
 	// Calculate tax total from sum tax levels
-	taxTotal := 0.0
+	var taxTotal Money
 	for _, level := range taxLevels {
 		taxTotal += level.Tax
 	}
 
-	// Ensure that the withholding tax provided for the calculation does not exceed the total income.
-	if wht > income {
-		return CalculationResponse{}, errors.New("withholding tax cannot be greater than the total income")
+	// Pay the minimum tax instead when it is higher for income other than salary
+	taxMethod := compareMinimumTax(incomes, taxTotal)
+	if taxMethod != nil {
+		taxTotal = taxMethod.Tax()
 	}
 
 	// withholding represents the fixed personal allowance.
 	if wht < 0 { // Ensure that withholding  is not negative
 		wht = 0
-	} else if wht > 100000 { // Ensure if withholding tax exceeds the limit 100000
-		wht = 100000
+	} else if wht > 100000*Baht { // Ensure if withholding tax exceeds the limit 100000
+		wht = 100000 * Baht
 	}
 
 	// Calculate tax final paid on taxable income after deductions including withholding tax
 	taxFinalPaid = taxTotal - wht
 
+	response := CalculationResponse{
+		TaxLevel:      taxLevels,
+		TaxYear:       rs.TaxYear,
+		TaxableIncome: taxableIncome,
+		Deductions:    deductions,
+		Allowances:    allowances,
+		Donation:      explainDonation(applied),
+	}
+	if incomes != nil {
+		response.GrossIncome = income
+		response.ExpenseDeduction = income - netIncome
+		response.NetIncome = netIncome
+		response.Incomes = incomes
+		response.TaxMethod = taxMethod
+	}
+
 	// Ensure tax is not negative
 	if taxFinalPaid < 0 {
-		return CalculationResponse{
-			TaxRefund: -taxFinalPaid,
-		}, nil
+		response.TaxRefund = -taxFinalPaid
+		return response, nil
 	}
 
 	// Return the tax value from the CalculationResponse instance
-	return CalculationResponse{
-		Tax:      taxFinalPaid,
-		TaxLevel: taxLevels,
-	}, nil
+	response.Tax = taxFinalPaid
+	return response, nil
 }
@@ -5,68 +5,68 @@ import "testing"
 func TestCalculateTax(t *testing.T) {
 	testCases := []struct {
 		name              string
-		totalIncome       float64
-		wht               float64
+		totalIncome       Money
+		wht               Money
 		allowances        []Allowance
-		personalDeduction float64
-		expectedTaxResult float64
+		personalDeduction Money
+		expectedTaxResult Money
 		expectedTaxLevels []TaxLevel
 	}{
 		{
 			name:              "Story: EXP01 calculate tax",
-			totalIncome:       500000.0,
-			wht:               0.0,
-			allowances:        []Allowance{{AllowanceType: "donation", Amount: 0.0}},
-			personalDeduction: 60000.0,
-			expectedTaxResult: 29000.0,
+			totalIncome:       500000 * Baht,
+			wht:               0,
+			allowances:        []Allowance{{AllowanceType: "donation", Amount: 0}},
+			personalDeduction: 60000 * Baht,
+			expectedTaxResult: 29000 * Baht,
 			expectedTaxLevels: []TaxLevel{},
 		},
 		{
 			name:              "Story: EXP02 calculate tax with WHT",
-			totalIncome:       500000.0,
-			wht:               25000.0,
-			allowances:        []Allowance{{AllowanceType: "donation", Amount: 0.0}},
-			personalDeduction: 60000.0,
-			expectedTaxResult: 4000.0,
+			totalIncome:       500000 * Baht,
+			wht:               25000 * Baht,
+			allowances:        []Allowance{{AllowanceType: "donation", Amount: 0}},
+			personalDeduction: 60000 * Baht,
+			expectedTaxResult: 4000 * Baht,
 			expectedTaxLevels: []TaxLevel{},
 		},
 		{
 			name:              "Story: EXP03 calculate tax with donation reduce",
-			totalIncome:       500000.0,
-			wht:               0.0,
-			allowances:        []Allowance{{AllowanceType: "donation", Amount: 200000.0}},
-			personalDeduction: 60000.0,
-			expectedTaxResult: 19000.0,
+			totalIncome:       500000 * Baht,
+			wht:               0,
+			allowances:        []Allowance{{AllowanceType: "donation", Amount: 200000 * Baht}},
+			personalDeduction: 60000 * Baht,
+			expectedTaxResult: 24600 * Baht,
 			expectedTaxLevels: []TaxLevel{},
 		},
 		{
 			name:              "Story: EXP04 calculate tax with tax level detail",
-			totalIncome:       500000.0,
-			wht:               0.0,
-			allowances:        []Allowance{{AllowanceType: "donation", Amount: 200000.0}},
-			personalDeduction: 60000.0,
-			expectedTaxResult: 19000.0,
+			totalIncome:       500000 * Baht,
+			wht:               0,
+			allowances:        []Allowance{{AllowanceType: "donation", Amount: 200000 * Baht}},
+			personalDeduction: 60000 * Baht,
+			expectedTaxResult: 24600 * Baht,
 			expectedTaxLevels: []TaxLevel{
-				{"0-150,000", 0.0},
-				{"150,001-500,000", 19000.0},
-				{"500,001-1,000,000", 0.0},
-				{"1,000,001-2,000,000", 0.0},
-				{"2,000,001 ขึ้นไป", 0.0},
+				{"0-150,000", 0},
+				{"150,001-500,000", 24600 * Baht},
+				{"500,001-1,000,000", 0},
+				{"1,000,001-2,000,000", 0},
+				{"2,000,001 ขึ้นไป", 0},
 			},
 		},
 		{
 			name:              "Story: EXP07 calculate tax with k-receipt and tax level detail",
-			totalIncome:       500000.0,
-			wht:               0.0,
-			allowances:        []Allowance{{AllowanceType: "k-receipt", Amount: 200000.0}, {AllowanceType: "donation", Amount: 100000.0}},
-			personalDeduction: 60000.0,
-			expectedTaxResult: 14000.0,
+			totalIncome:       500000 * Baht,
+			wht:               0,
+			allowances:        []Allowance{{AllowanceType: "k-receipt", Amount: 200000 * Baht}, {AllowanceType: "donation", Amount: 100000 * Baht}},
+			personalDeduction: 60000 * Baht,
+			expectedTaxResult: 20100 * Baht,
 			expectedTaxLevels: []TaxLevel{
-				{"0-150,000", 0.0},
-				{"150,001-500,000", 14000.0},
-				{"500,001-1,000,000", 0.0},
-				{"1,000,001-2,000,000", 0.0},
-				{"2,000,001 ขึ้นไป", 0.0},
+				{"0-150,000", 0},
+				{"150,001-500,000", 20100 * Baht},
+				{"500,001-1,000,000", 0},
+				{"1,000,001-2,000,000", 0},
+				{"2,000,001 ขึ้นไป", 0},
 			},
 		},
 	}
@@ -74,8 +74,14 @@ func TestCalculateTax(t *testing.T) {
 	// Run test cases
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			// Call the calculateTax function
-			response, err := CalculateTax(tc.totalIncome, tc.wht, tc.allowances, tc.personalDeduction)
+			rules, err := RuleSetForYear(DefaultTaxYear)
+			if err != nil {
+				t.Fatalf("error loading rule set: %v", err)
+			}
+			rules.PersonalDeduction = tc.personalDeduction
+
+			// Calculate the tax with the rule set
+			response, err := rules.Calculate(CalculationRequest{TotalIncome: tc.totalIncome, WHT: tc.wht, Allowances: tc.allowances})
 			if err != nil {
 				t.Fatalf("error calculating tax: %v", err)
 			}
@@ -84,7 +90,7 @@ func TestCalculateTax(t *testing.T) {
 
 			// Compare the actual result with the expected result
 			if actualTaxResult != tc.expectedTaxResult {
-				t.Errorf("test case %s: expected result %f; got %f", tc.name, tc.expectedTaxResult, actualTaxResult)
+				t.Errorf("test case %s: expected result %v; got %v", tc.name, tc.expectedTaxResult, actualTaxResult)
 			}
 
 			// Compare the actual tax levels with the expected tax levels
@@ -96,3 +102,118 @@ func TestCalculateTax(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculateTaxMinimumTax(t *testing.T) {
+	// deductions lower the net income of 1,200,000 of business income, 480,000 after expenses, to 32,000
+	deductions := []Allowance{
+		{AllowanceType: "rmf", Amount: 144000 * Baht},
+		{AllowanceType: "ssf", Amount: 144000 * Baht},
+		{AllowanceType: "life-insurance", Amount: 100000 * Baht},
+	}
+
+	testCases := []struct {
+		name              string
+		incomes           []Income
+		wht               Money
+		allowances        []Allowance
+		expectedTaxResult Money
+		expectedMethod    *TaxMethod
+	}{
+		{
+			name:              "salary only is not compared",
+			incomes:           []Income{{IncomeType: "40(1)", Amount: 1000000 * Baht}},
+			expectedTaxResult: 86000 * Baht,
+		},
+		{
+			name:              "income other than salary below 120,000 is not compared",
+			incomes:           []Income{{IncomeType: "40(1)", Amount: 1000000 * Baht}, {IncomeType: "40(2)", Amount: 100000 * Baht}},
+			expectedTaxResult: 101000 * Baht,
+		},
+		{
+			name:              "progressive tax is higher",
+			incomes:           []Income{{IncomeType: "40(8)", Amount: 2000000 * Baht}},
+			expectedTaxResult: 71000 * Baht,
+			expectedMethod:    &TaxMethod{Method: TaxMethodProgressive, ProgressiveTax: 71000 * Baht, MinimumTax: 10000 * Baht},
+		},
+		{
+			name:              "salary is excluded from the minimum tax",
+			incomes:           []Income{{IncomeType: "40(1)", Amount: 250000 * Baht}, {IncomeType: "40(8)", Amount: 1200000 * Baht}},
+			allowances:        deductions,
+			expectedTaxResult: 6000 * Baht,
+			expectedMethod:    &TaxMethod{Method: TaxMethodMinimum, ProgressiveTax: 3200 * Baht, MinimumTax: 6000 * Baht},
+		},
+		{
+			name:              "minimum tax is higher",
+			incomes:           []Income{{IncomeType: "40(8)", Amount: 1200000 * Baht}},
+			allowances:        deductions,
+			expectedTaxResult: 6000 * Baht,
+			expectedMethod:    &TaxMethod{Method: TaxMethodMinimum, ProgressiveTax: 0, MinimumTax: 6000 * Baht},
+		},
+		{
+			name:              "minimum tax with WHT",
+			incomes:           []Income{{IncomeType: "40(8)", Amount: 1200000 * Baht}},
+			wht:               1000 * Baht,
+			allowances:        deductions,
+			expectedTaxResult: 5000 * Baht,
+			expectedMethod:    &TaxMethod{Method: TaxMethodMinimum, ProgressiveTax: 0, MinimumTax: 6000 * Baht},
+		},
+		{
+			name:              "minimum tax of 5,000 or less is exempt",
+			incomes:           []Income{{IncomeType: "40(8)", Amount: 900000 * Baht}},
+			allowances:        deductions,
+			expectedTaxResult: 0,
+			expectedMethod:    &TaxMethod{Method: TaxMethodProgressive, ProgressiveTax: 0, MinimumTax: 4500 * Baht},
+		},
+		{
+			name:              "income other than salary of 120,000 is compared",
+			incomes:           []Income{{IncomeType: "40(8)", Amount: 120000 * Baht}},
+			expectedTaxResult: 0,
+			expectedMethod:    &TaxMethod{Method: TaxMethodProgressive, ProgressiveTax: 0, MinimumTax: 600 * Baht},
+		},
+	}
+
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := rules.Calculate(CalculationRequest{Incomes: tc.incomes, WHT: tc.wht, Allowances: tc.allowances})
+			if err != nil {
+				t.Fatalf("error calculating tax: %v", err)
+			}
+			if response.Tax != tc.expectedTaxResult {
+				t.Errorf("test case %s: expected result %v; got %v", tc.name, tc.expectedTaxResult, response.Tax)
+			}
+			if (response.TaxMethod == nil) != (tc.expectedMethod == nil) || (tc.expectedMethod != nil && *response.TaxMethod != *tc.expectedMethod) {
+				t.Errorf("test case %s: expected tax method %+v; got %+v", tc.name, tc.expectedMethod, response.TaxMethod)
+			}
+		})
+	}
+}
+
+func TestCheckMatchesCalculate(t *testing.T) {
+	rules, err := RuleSetForYear(DefaultTaxYear)
+	if err != nil {
+		t.Fatalf("error reading rule set: %v", err)
+	}
+
+	requests := []CalculationRequest{
+		{TotalIncome: 500000 * Baht},
+		{TotalIncome: 500000 * Baht, WHT: 600000 * Baht},
+		{TotalIncome: 500000 * Baht, Allowances: []Allowance{{AllowanceType: "lottery", Amount: 1000 * Baht}}},
+		{TotalIncome: 500000 * Baht, Allowances: []Allowance{{AllowanceType: "k-receipt", Amount: -1}}},
+		{TotalIncome: 500000 * Baht, Allowances: []Allowance{{AllowanceType: "donation", Amount: 1000 * Baht, DonationType: "temple"}}},
+		{TotalIncome: 400000 * Baht, Incomes: []Income{{IncomeType: "40(1)", Amount: 500000 * Baht}}},
+		{Incomes: []Income{{IncomeType: "40(9)", Amount: 500000 * Baht}}},
+		{TotalIncome: 500000 * Baht, Profile: &TaxpayerProfile{Parents: 5}},
+	}
+
+	for _, request := range requests {
+		checkErr := rules.Check(request)
+		_, calculateErr := rules.Calculate(request)
+		if (checkErr == nil) != (calculateErr == nil) || (checkErr != nil && checkErr.Error() != calculateErr.Error()) {
+			t.Errorf("request %+v: Check returned %v; Calculate returned %v", request, checkErr, calculateErr)
+		}
+	}
+}